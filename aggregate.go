@@ -0,0 +1,214 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Counts
+
+// A nil keyName counts every row, less any BeforeFind or soft-delete filtering.
+func (entityDescription *EntityDescription) Count(transaction *sql.Tx, keyName *string, value interface{}) (count int64, err error) {
+	var (
+		clause *WhereClause
+	)
+
+	if keyName != nil {
+		clause = Where(*keyName, "=", value)
+	}
+
+	err = entityDescription.aggregate(transaction, "COUNT", "*", clause, &count)
+
+	return count, err
+}
+
+func (entityDescription *EntityDescription) Exists(transaction *sql.Tx, keyName *string, value interface{}) (exists bool, err error) {
+	var (
+		count int64
+	)
+
+	count, err = entityDescription.Count(transaction, keyName, value)
+
+	return count > 0, err
+}
+
+// Typed Aggregates
+
+// Each helper reports false alongside the zero value when the aggregate is
+// NULL, which is what SUM, MIN and MAX give over an empty set. column must be
+// one the description knows, since it is written into the SQL.
+
+func (entityDescription *EntityDescription) SumInt64(transaction *sql.Tx, column string, clause *WhereClause) (int64, bool, error) {
+	return entityDescription.aggregateInt64(transaction, "SUM", column, clause)
+}
+
+func (entityDescription *EntityDescription) MinInt64(transaction *sql.Tx, column string, clause *WhereClause) (int64, bool, error) {
+	return entityDescription.aggregateInt64(transaction, "MIN", column, clause)
+}
+
+func (entityDescription *EntityDescription) MaxInt64(transaction *sql.Tx, column string, clause *WhereClause) (int64, bool, error) {
+	return entityDescription.aggregateInt64(transaction, "MAX", column, clause)
+}
+
+func (entityDescription *EntityDescription) SumFloat64(transaction *sql.Tx, column string, clause *WhereClause) (float64, bool, error) {
+	return entityDescription.aggregateFloat64(transaction, "SUM", column, clause)
+}
+
+func (entityDescription *EntityDescription) MinFloat64(transaction *sql.Tx, column string, clause *WhereClause) (float64, bool, error) {
+	return entityDescription.aggregateFloat64(transaction, "MIN", column, clause)
+}
+
+func (entityDescription *EntityDescription) MaxFloat64(transaction *sql.Tx, column string, clause *WhereClause) (float64, bool, error) {
+	return entityDescription.aggregateFloat64(transaction, "MAX", column, clause)
+}
+
+func (entityDescription *EntityDescription) MinString(transaction *sql.Tx, column string, clause *WhereClause) (string, bool, error) {
+	return entityDescription.aggregateString(transaction, "MIN", column, clause)
+}
+
+func (entityDescription *EntityDescription) MaxString(transaction *sql.Tx, column string, clause *WhereClause) (string, bool, error) {
+	return entityDescription.aggregateString(transaction, "MAX", column, clause)
+}
+
+func (entityDescription *EntityDescription) MinTime(transaction *sql.Tx, column string, clause *WhereClause) (time.Time, bool, error) {
+	return entityDescription.aggregateTime(transaction, "MIN", column, clause)
+}
+
+func (entityDescription *EntityDescription) MaxTime(transaction *sql.Tx, column string, clause *WhereClause) (time.Time, bool, error) {
+	return entityDescription.aggregateTime(transaction, "MAX", column, clause)
+}
+
+type AggregateFunc string
+
+const (
+	AggregateSum AggregateFunc = "SUM"
+	AggregateAvg AggregateFunc = "AVG"
+	AggregateMin AggregateFunc = "MIN"
+	AggregateMax AggregateFunc = "MAX"
+)
+
+var ErrUnsupportedAggregate = errors.New("bccdata: unsupported aggregate function")
+
+// Applies function to column over the rows where keyName equals value, or
+// every row when keyName is nil.
+func (entityDescription *EntityDescription) Aggregate(transaction *sql.Tx, function AggregateFunc, column string, keyName *string, value interface{}) (float64, bool, error) {
+	var (
+		clause *WhereClause
+	)
+
+	switch function {
+	case AggregateSum, AggregateAvg, AggregateMin, AggregateMax:
+	default:
+		return 0, false, fmt.Errorf("%w: %s", ErrUnsupportedAggregate, function)
+	}
+
+	if keyName != nil {
+		clause = Where(*keyName, "=", value)
+	}
+
+	return entityDescription.aggregateFloat64(transaction, string(function), column, clause)
+}
+
+func (entityDescription *EntityDescription) aggregateInt64(transaction *sql.Tx, function string, column string, clause *WhereClause) (int64, bool, error) {
+	var value sql.NullInt64
+
+	err := entityDescription.aggregate(transaction, function, column, clause, &value)
+
+	return value.Int64, value.Valid, err
+}
+
+func (entityDescription *EntityDescription) aggregateFloat64(transaction *sql.Tx, function string, column string, clause *WhereClause) (float64, bool, error) {
+	var value sql.NullFloat64
+
+	err := entityDescription.aggregate(transaction, function, column, clause, &value)
+
+	return value.Float64, value.Valid, err
+}
+
+func (entityDescription *EntityDescription) aggregateString(transaction *sql.Tx, function string, column string, clause *WhereClause) (string, bool, error) {
+	var value sql.NullString
+
+	err := entityDescription.aggregate(transaction, function, column, clause, &value)
+
+	return value.String, value.Valid, err
+}
+
+// Time columns may hold Unix seconds (as Create stamps them), text, or a native
+// timestamp, so the raw value goes through the scanner's coercion.
+func (entityDescription *EntityDescription) aggregateTime(transaction *sql.Tx, function string, column string, clause *WhereClause) (time.Time, bool, error) {
+	var (
+		value   interface{}
+		coerced interface{}
+		err     error
+	)
+
+	err = entityDescription.aggregate(transaction, function, column, clause, &value)
+	if err != nil || value == nil {
+		return time.Time{}, false, err
+	}
+
+	coerced, err = coerceValue(value, "", timeType)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return coerced.(time.Time), true, nil
+}
+
+func (entityDescription *EntityDescription) aggregate(transaction *sql.Tx, function string, column string, clause *WhereClause, destination interface{}) (err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereClause     *WhereClause
+		whereSQL        string
+		args            []interface{}
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+	whereClause = clause.clone()
+
+	if column != "*" {
+		err = entityDescription.checkKnownColumn(column)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, "")
+	if err != nil {
+		return err
+	}
+
+	selectStatement = fmt.Sprintf("SELECT %s(%s) FROM %s%s", function, databaseContext.quoteIdentifier(column), entityDescription.readSource(), whereSQL)
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, selectStatement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = rows.Err()
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+
+		return err
+	}
+
+	err = rows.Scan(destination)
+	if err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
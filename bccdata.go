@@ -1,40 +1,220 @@
 package bccdata
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+var (
+	ErrTruncateNotConfirmed = errors.New("bccdata: TruncateAll requires confirmation")
+	ErrUnknownEntity        = errors.New("bccdata: unknown entity")
+	ErrUnknownRelationship  = errors.New("bccdata: unknown relationship")
+	ErrMissingSourceKey     = errors.New("bccdata: relationship has no SourceKey")
+	ErrMetadataMismatch     = errors.New("bccdata: entity and metadata rows do not line up")
+	ErrNoInsertColumns      = errors.New("bccdata: no insertable columns")
+	ErrNoUpdateColumns      = errors.New("bccdata: no columns to update")
+	ErrReadOnlyEntity       = errors.New("bccdata: entity is read-only")
+	ErrReadOnlyColumn       = errors.New("bccdata: column is read-only")
+	ErrColumnsRequired      = errors.New("bccdata: WriteOnlyColumns needs Columns declared")
+	ErrMultipleResults      = errors.New("bccdata: more than one row matched")
+	ErrNotFound             = errors.New("bccdata: no row matched")
+	ErrUnknownColumn        = errors.New("bccdata: unknown column")
+	ErrNoInsertStatement    = errors.New("bccdata: Create needs BuildInsertStatement first")
+	ErrNotJoinRelationship  = errors.New("bccdata: relationship has no join table")
+	ErrKeyMismatch          = errors.New("bccdata: key values do not match PrimaryKeys")
+	ErrInvalidReadSQL       = errors.New("bccdata: ReadSQL needs exactly one {{WHERE}}")
+)
+
+// The same error as ErrMultipleResults, under the name FindEntity's callers
+// tend to look for.
+var ErrMultipleFound = ErrMultipleResults
+
 type DatabaseContext struct {
-	Database           *sql.DB
-	EntityDescriptions map[string]EntityDescription
+	Database                *sql.DB
+	ReadDatabase            *sql.DB
+	ConnectionRouter        func(entity string, op OpKind) *sql.DB
+	EntityDescriptions      map[string]EntityDescription
+	Dialect                 Dialect
+	QuoteIdentifiers        bool
+	CursorSecret            []byte
+	OutboxEntityName        string
+	TransactionRetryLimit   int
+	TransactionRetryBackoff time.Duration
+	IsRetryable             func(err error) bool
+	QueryTimeout            time.Duration
+	QueryRewriter           func(sql string, args []interface{}) (string, []interface{}, error)
+	MultiStatementExec      bool
+	NameMapper              NameMapper
+	Logger                  Logger
+	CacheReadStatements     bool
+	entityDescriptionsLock  sync.RWMutex
+	statementCache          map[statementCacheKey]*sql.Stmt
+	statementCacheLock      sync.Mutex
 }
 
+type RelationshipKind int
+
+const (
+	ManyToMany RelationshipKind = iota
+	HasMany
+)
+
+// A ManyToMany relationship goes through JoinTableName, whose SourceKey column
+// holds the source's key and whose ForeignKey column matches the target's
+// TargetKey. A HasMany relationship reads the target table directly, matching
+// its ForeignKey column against the source's key; JoinTableName, SourceKey and
+// TargetKey are unused. One with no JoinTableName is HasMany whatever its Kind.
 type EntityRelationship struct {
 	EntityName    string
+	Kind          RelationshipKind
 	JoinTableName string
+	SourceKey     string
 	ForeignKey    string
 	TargetKey     string
+	CounterColumn string
+}
+
+func (relationship EntityRelationship) kind() RelationshipKind {
+	if relationship.JoinTableName == "" {
+		return HasMany
+	}
+
+	return relationship.Kind
+}
+
+// ZeroAsNull binds NULL in place of a Go zero value written to the column, so
+// the column's default applies instead of an explicit empty value. Type and
+// NotNull are used only by CreateTable.
+type ColumnDef struct {
+	Name       string
+	Type       string
+	NotNull    bool
+	Generated  bool
+	ZeroAsNull bool
 }
 
 type EntityDescription struct {
-	Name               string
-	TableName          string
-	PrimaryKey         string
-	Relationships      map[string]EntityRelationship
-	InsertStatement    *sql.Stmt
-	CreateZeroInstance func() Entity
-	Context            *DatabaseContext
+	Name                string
+	TableName           string
+	PrimaryKey          string
+	PrimaryKeys         []string
+	Columns             []ColumnDef
+	SourceSQL           string
+	ReadSQL             string
+	ReadOnly            bool
+	ReadOnlyColumns     []string
+	WriteOnlyColumns    []string
+	SelectColumns       []string
+	CreatedDateColumn   string
+	VersionColumn       string
+	UpdatedDateColumn   string
+	SoftDeleteColumn    string
+	ConflictWhere       string
+	SearchColumn        string
+	SearchConfiguration string
+	Relationships       map[string]EntityRelationship
+	LazyRelationships   []string
+	BatchSize           int
+	InsertStatement     *sql.Stmt
+	insertReturning     bool
+	insertSQL           string
+	CreateZeroInstance  func() Entity
+	BeforeFind          func(clause *WhereClause) error
+	AfterFind           func(entities []Entity) error
+	BeforeCreate        func(args []interface{}) error
+	AfterCreate         func(entity Entity) error
+	BeforeUpdate        func(id interface{}, fields map[string]interface{}) error
+	AfterUpdate         func(entity Entity) error
+	BeforeDelete        func(id interface{}) error
+	AfterDelete         func(id interface{}) error
+	Context             *DatabaseContext
 }
 
+// Rows per statement for CreateMany when BatchSize is unset.
+const DefaultBatchSize = 500
+
+// ScanFromRow advances rows and scans the next row into the entity. It
+// returns false with a nil error once the rows are exhausted; any error, with
+// either result, aborts the read and is passed on.
 type Entity interface {
 	ScanFromRow(*sql.Rows) (bool, error)
 }
 
+type KeyedEntity interface {
+	Entity
+	PrimaryKeyValue() interface{}
+}
+
+// Entities found through a description with LazyRelationships are handed a
+// loader for each of them; see attachLazyLoaders.
+type LazyRelationshipEntity interface {
+	KeyedEntity
+	SetRelationshipLoader(relationshipName string, loader func() ([]Entity, error))
+}
+
+// StrictSingle, the default, makes single-entity finders fail with
+// ErrMultipleResults when more than one row matches; FirstMatch returns the
+// first row instead.
+type MatchMode int
+
+const (
+	StrictSingle MatchMode = iota
+	FirstMatch
+)
+
+// Locking options need a transaction to hold the lock, and fail without one.
+// Match only applies to the single-entity finders. OrderBy columns must be
+// among those the description knows. SkipCache hands back the rows as read,
+// mapping them in place of any instance the context's identity map holds.
+type FindOptions struct {
+	ForShare  bool
+	Match     MatchMode
+	OrderBy   []OrderBy
+	SkipCache bool
+}
+
+type OrderBy struct {
+	Column     string
+	Descending bool
+}
+
+// Entity is nil when the create skipped reading the row back, and CreatedAt is
+// zero when the description has no CreatedDateColumn.
+type CreateResult struct {
+	Entity    Entity
+	ID        int64
+	CreatedAt time.Time
+}
+
+// Entity is nil when the update skipped reading the row back, and UpdatedAt is
+// zero when the description has no UpdatedDateColumn.
+type UpdateResult struct {
+	Entity    Entity
+	UpdatedAt time.Time
+}
+
+type EntityWithMeta struct {
+	Entity     Entity
+	Version    interface{}
+	ModifiedAt time.Time
+}
+
 // Entity Descriptions
 
+// Registration and lookup are safe to use from several goroutines at once.
+// Writing to EntityDescriptions directly is not, once other goroutines may be
+// reading it.
 func (databaseContext *DatabaseContext) RegisterEntityDescription(entityDescription EntityDescription) {
+	databaseContext.entityDescriptionsLock.Lock()
+	defer databaseContext.entityDescriptionsLock.Unlock()
+
 	if databaseContext.EntityDescriptions == nil {
 		databaseContext.EntityDescriptions = make(map[string]EntityDescription)
 	}
@@ -45,200 +225,2497 @@ func (databaseContext *DatabaseContext) RegisterEntityDescription(entityDescript
 }
 
 func (databaseContext *DatabaseContext) EntityDescriptionForName(entityName string) (entityDescription EntityDescription) {
-	return databaseContext.EntityDescriptions[entityName]
+	entityDescription, _ = databaseContext.lookupEntityDescription(entityName)
+	return entityDescription
 }
 
-// Entity Relationships
+func (databaseContext *DatabaseContext) lookupEntityDescription(entityName string) (entityDescription EntityDescription, ok bool) {
+	databaseContext.entityDescriptionsLock.RLock()
+	defer databaseContext.entityDescriptionsLock.RUnlock()
 
-func (entityDescription *EntityDescription) RegisterRelationship(entityRelationship EntityRelationship) {
-	if entityDescription.Relationships == nil {
-		entityDescription.Relationships = make(map[string]EntityRelationship)
+	entityDescription, ok = databaseContext.EntityDescriptions[entityName]
+	return entityDescription, ok
+}
+
+// A copy taken under the lock, in entity name order, for callers that walk
+// every registered entity.
+func (databaseContext *DatabaseContext) registeredEntityDescriptions() (entityDescriptions []EntityDescription) {
+	databaseContext.entityDescriptionsLock.RLock()
+	defer databaseContext.entityDescriptionsLock.RUnlock()
+
+	for _, entityDescription := range databaseContext.EntityDescriptions {
+		entityDescriptions = append(entityDescriptions, entityDescription)
 	}
 
-	entityDescription.Relationships[entityRelationship.EntityName] = entityRelationship
+	sort.Slice(entityDescriptions, func(i, j int) bool {
+		return entityDescriptions[i].Name < entityDescriptions[j].Name
+	})
+
+	return entityDescriptions
 }
 
-func (entityDescription *EntityDescription) RelationshipForName(entityName string) (entityRelationship EntityRelationship) {
-	return entityDescription.Relationships[entityName]
+// Entity Columns
+
+func (entityDescription *EntityDescription) isGeneratedColumn(columnName string) bool {
+	for _, column := range entityDescription.Columns {
+		if column.Generated && strings.EqualFold(column.Name, columnName) {
+			return true
+		}
+	}
+
+	return false
 }
 
-// Entity Creation
+func (entityDescription *EntityDescription) insertColumns() (columnNames []string) {
+	for _, column := range entityDescription.Columns {
+		if !column.Generated && !containsColumn(entityDescription.ReadOnlyColumns, column.Name) {
+			columnNames = append(columnNames, column.Name)
+		}
+	}
 
-func (entityDescription *EntityDescription) Create(transaction *sql.Tx, args ...interface{}) (entity Entity, err error) {
+	return columnNames
+}
+
+// values line up with columnNames. The caller's slice is left as it is.
+func (entityDescription *EntityDescription) bindValues(columnNames []string, values []interface{}) (boundValues []interface{}) {
+	boundValues = append([]interface{}(nil), values...)
+
+	for _, column := range entityDescription.Columns {
+		if !column.ZeroAsNull {
+			continue
+		}
+
+		for index, columnName := range columnNames {
+			if strings.EqualFold(column.Name, columnName) && boundValues[index] != nil && reflect.ValueOf(boundValues[index]).IsZero() {
+				boundValues[index] = nil
+			}
+		}
+	}
+
+	return boundValues
+}
+
+// Writers that take column names refuse ReadOnlyColumns outright rather than
+// dropping them, so an attempt to write one is visible to the caller.
+func (entityDescription *EntityDescription) checkWritableColumns(columnNames []string) error {
+	for _, columnName := range columnNames {
+		if containsColumn(entityDescription.ReadOnlyColumns, columnName) {
+			return fmt.Errorf("%w: %s", ErrReadOnlyColumn, columnName)
+		}
+	}
+
+	return nil
+}
+
+// The select list is * unless SelectColumns or WriteOnlyColumns are set.
+// SelectColumns is the whole list, in order, so ScanFromRow sees the same
+// columns however the table is laid out. Otherwise it is the primary key
+// columns, then every declared column except the WriteOnlyColumns, then the
+// created, updated, version and soft-delete columns that are set and not
+// declared. qualifier prefixes each column for use inside a join.
+func (entityDescription *EntityDescription) selectColumns(qualifier string) (selectSQL string, err error) {
 	var (
-		commitAtEnd          bool
-		insertStatement      *sql.Stmt
-		result               sql.Result
-		objectID             int64
-		createdTime          int64
-		tableName            string
-		updateCreatedDateSQL string
-		querySQL             string
-		rows                 *sql.Rows
-		scanSuccess          bool
+		databaseContext *DatabaseContext
+		selectNames     []string
+		columnNames     []string
 	)
 
-	commitAtEnd = false
-	if transaction == nil {
-		transaction, err = entityDescription.Context.Database.Begin()
-		commitAtEnd = true
-		if err != nil {
-			goto cleanup
+	databaseContext = entityDescription.Context
+
+	switch {
+	case len(entityDescription.SelectColumns) > 0:
+		selectNames = entityDescription.SelectColumns
+	case len(entityDescription.WriteOnlyColumns) == 0:
+		if qualifier == "" {
+			return "*", nil
 		}
 
-		commitAtEnd = true
+		return databaseContext.quoteIdentifier(qualifier) + ".*", nil
+	case len(entityDescription.Columns) == 0:
+		return "", fmt.Errorf("%w: %s", ErrColumnsRequired, entityDescription.Name)
+	default:
+		candidateNames := append([]string(nil), entityDescription.PrimaryKeys...)
+		if len(candidateNames) == 0 {
+			candidateNames = []string{entityDescription.PrimaryKey}
+		}
+
+		for _, column := range entityDescription.Columns {
+			candidateNames = append(candidateNames, column.Name)
+		}
+
+		candidateNames = append(candidateNames, entityDescription.managedColumns()...)
+
+		for _, columnName := range candidateNames {
+			if columnName != "" && !containsColumn(entityDescription.WriteOnlyColumns, columnName) && !containsColumn(selectNames, columnName) {
+				selectNames = append(selectNames, columnName)
+			}
+		}
 	}
 
-	insertStatement = transaction.Stmt(entityDescription.InsertStatement)
+	for _, columnName := range selectNames {
+		if qualifier == "" {
+			columnNames = append(columnNames, columnName)
+		} else {
+			columnNames = append(columnNames, qualifier+"."+columnName)
+		}
+	}
 
-	result, err = insertStatement.Exec(args...)
-	if err != nil {
-		goto cleanup
+	return strings.Join(databaseContext.quoteIdentifiers(columnNames), ", "), nil
+}
+
+// The columns the package writes itself, in a fixed order, skipping those
+// left unset.
+func (entityDescription *EntityDescription) managedColumns() (columnNames []string) {
+	for _, columnName := range []string{entityDescription.CreatedDateColumn, entityDescription.UpdatedDateColumn, entityDescription.VersionColumn, entityDescription.SoftDeleteColumn} {
+		if columnName != "" {
+			columnNames = append(columnNames, columnName)
+		}
 	}
 
-	objectID, err = result.LastInsertId()
-	if err != nil {
-		goto cleanup
+	return columnNames
+}
+
+// Column names that have to be written into SQL rather than bound, such as
+// ORDER BY columns, must be the primary key, a declared or selected column, or
+// one of the columns the package stamps itself.
+func (entityDescription *EntityDescription) checkKnownColumn(columnName string) error {
+	if strings.EqualFold(columnName, entityDescription.PrimaryKey) || containsColumn(entityDescription.PrimaryKeys, columnName) {
+		return nil
 	}
 
-	tableName = entityDescription.TableName
+	if containsColumn(entityDescription.SelectColumns, columnName) || containsColumn(entityDescription.managedColumns(), columnName) {
+		return nil
+	}
 
-	createdTime = time.Now().Unix()
-	updateCreatedDateSQL = fmt.Sprintf("UPDATE %s SET createdDate=? WHERE id=?", tableName)
-	result, err = transaction.Exec(updateCreatedDateSQL, createdTime, objectID)
-	if err != nil {
-		goto cleanup
+	for _, column := range entityDescription.Columns {
+		if strings.EqualFold(column.Name, columnName) {
+			return nil
+		}
 	}
 
-	querySQL = fmt.Sprintf("SELECT * FROM %s WHERE id=?", tableName)
-	rows, err = transaction.Query(querySQL, objectID)
-	if err != nil {
-		goto cleanup
+	return fmt.Errorf("%w: %s has no column %s", ErrUnknownColumn, entityDescription.Name, columnName)
+}
+
+func (entityDescription *EntityDescription) orderBySQL(orderBy []OrderBy) (orderSQL string, err error) {
+	var (
+		terms []string
+	)
+
+	for _, order := range orderBy {
+		err = entityDescription.checkKnownColumn(order.Column)
+		if err != nil {
+			return "", err
+		}
+
+		term := entityDescription.Context.quoteIdentifier(order.Column) + " ASC"
+		if order.Descending {
+			term = entityDescription.Context.quoteIdentifier(order.Column) + " DESC"
+		}
+
+		terms = append(terms, term)
 	}
 
-	entity = entityDescription.CreateZeroInstance()
-	scanSuccess, err = entity.ScanFromRow(rows)
-	if !scanSuccess {
-		goto cleanup
+	if len(terms) == 0 {
+		return "", nil
 	}
 
-cleanup:
-	if rows != nil {
-		defer rows.Close()
+	return " ORDER BY " + strings.Join(terms, ", "), nil
+}
+
+func containsColumn(columnNames []string, columnName string) bool {
+	for _, candidate := range columnNames {
+		if strings.EqualFold(candidate, columnName) {
+			return true
+		}
 	}
 
-	if insertStatement != nil {
-		defer insertStatement.Close()
+	return false
+}
+
+// Primary Keys
+
+// PrimaryKeys, when set, names a composite key and takes over from PrimaryKey
+// wherever a key value is a []interface{} holding one value per column, in
+// order. Everywhere else PrimaryKey is used as it always was.
+func (entityDescription *EntityDescription) primaryKeyClause(value interface{}) (whereClause *WhereClause, err error) {
+	if len(entityDescription.PrimaryKeys) == 0 {
+		return Where(entityDescription.PrimaryKey, "=", value), nil
 	}
 
-	if commitAtEnd {
-		if err != nil {
-			transaction.Rollback()
-		} else {
-			transaction.Commit()
+	keyValues, ok := value.([]interface{})
+	if !ok || len(keyValues) != len(entityDescription.PrimaryKeys) {
+		return nil, fmt.Errorf("%w: %s needs %d key values", ErrKeyMismatch, entityDescription.Name, len(entityDescription.PrimaryKeys))
+	}
+
+	whereClause = &WhereClause{}
+	for index, keyColumn := range entityDescription.PrimaryKeys {
+		whereClause.And(keyColumn, "=", keyValues[index])
+	}
+
+	return whereClause, nil
+}
+
+// Picks the composite key's values out of a row being inserted, nil when
+// there is no composite key.
+func (entityDescription *EntityDescription) primaryKeyValues(columnNames []string, values []interface{}) (keyValues []interface{}, err error) {
+	for _, keyColumn := range entityDescription.PrimaryKeys {
+		found := false
+
+		for index, columnName := range columnNames {
+			if strings.EqualFold(columnName, keyColumn) && index < len(values) {
+				keyValues = append(keyValues, values[index])
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%w: %s has no value for %s", ErrKeyMismatch, entityDescription.Name, keyColumn)
 		}
 	}
 
-	return entity, err
+	return keyValues, nil
 }
 
-func (entityDescription *EntityDescription) CreateFromRows(rows *sql.Rows) (entities []Entity, err error) {
-	var (
-		scanSuccess bool
-	)
+// Entity Sources
 
-	for {
-		entity := entityDescription.CreateZeroInstance()
+// SourceSQL, typically a "(SELECT ...) AS name" subquery, replaces the table in
+// every read. TableName still qualifies columns in joins, so such a subquery
+// should be aliased to the TableName. Entities backed by SourceSQL, or marked
+// ReadOnly for views, refuse writes with ErrReadOnlyEntity.
+func (entityDescription *EntityDescription) readSource() string {
+	if entityDescription.SourceSQL != "" {
+		return entityDescription.SourceSQL
+	}
 
-		scanSuccess, err = entity.ScanFromRow(rows)
-		if !scanSuccess {
-			break
+	return entityDescription.Context.quoteIdentifier(entityDescription.TableName)
+}
+
+// ReadSQL goes further and replaces the whole SELECT that finders returning
+// entities run, so it can add computed columns for the scanner. The finder's
+// conditions, WHERE keyword included, are put in place of {{WHERE}}, and any
+// ORDER BY or LIMIT is appended after the template. Writes still go to
+// TableName. A template without exactly one {{WHERE}} is ErrInvalidReadSQL,
+// since the finder's conditions would otherwise be dropped.
+func (entityDescription *EntityDescription) readSQL(selectColumns string, whereSQL string) (string, error) {
+	if entityDescription.ReadSQL != "" {
+		if strings.Count(entityDescription.ReadSQL, "{{WHERE}}") != 1 {
+			return "", fmt.Errorf("%w: %s", ErrInvalidReadSQL, entityDescription.Name)
 		}
 
-		entities = append(entities, entity)
+		return strings.Replace(entityDescription.ReadSQL, "{{WHERE}}", whereSQL, 1), nil
 	}
 
-	return entities, err
+	return fmt.Sprintf("SELECT %s FROM %s%s", selectColumns, entityDescription.readSource(), whereSQL), nil
 }
 
-// Entity Find
+func (entityDescription *EntityDescription) checkWritable() error {
+	if entityDescription.ReadOnly || entityDescription.SourceSQL != "" {
+		return fmt.Errorf("%w: %s", ErrReadOnlyEntity, entityDescription.Name)
+	}
 
-func (entityDescription *EntityDescription) FindEntity(transaction *sql.Tx, keyName *string, value interface{}) (entity Entity, err error) {
-	entities, err := entityDescription.FindEntities(transaction, keyName, value)
-	return entities[0], err
+	return nil
 }
 
-func (entityDescription *EntityDescription) FindEntities(transaction *sql.Tx, keyName *string, value interface{}) (entities []Entity, err error) {
+// Prepares InsertStatement from the declared non-generated Columns, in order,
+// so Create takes one argument per such column.
+func (entityDescription *EntityDescription) BuildInsertStatement(databaseContext *DatabaseContext) (err error) {
 	var (
-		tableName       string
-		columnName      string
-		selectStatement string
-		rows            *sql.Rows
+		columnNames  []string
+		placeholders []string
+		insertSQL    string
 	)
 
-	tableName = entityDescription.TableName
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return err
+	}
 
-	if keyName == nil {
-		columnName = entityDescription.PrimaryKey
-	} else {
-		columnName = *keyName
+	columnNames = entityDescription.insertColumns()
+	if len(columnNames) == 0 {
+		return ErrNoInsertColumns
+	}
+
+	for range columnNames {
+		placeholders = append(placeholders, "?")
 	}
 
-	selectStatement = fmt.Sprintf("SELECT * FROM %s WHERE %s=?", tableName, columnName)
+	insertSQL = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", databaseContext.quoteIdentifier(entityDescription.TableName), strings.Join(databaseContext.quoteIdentifiers(columnNames), ", "), strings.Join(placeholders, ", "))
 
-	if transaction != nil {
-		rows, err = transaction.Query(selectStatement, value)
-	} else {
-		rows, err = entityDescription.Context.Database.Query(selectStatement, value)
+	insertSQL, _, err = databaseContext.rewriteQuery(insertSQL, nil)
+	if err != nil {
+		return err
+	}
+
+	// A composite-key table has no single key column to return.
+	returningSQL := insertSQL
+	if len(entityDescription.PrimaryKeys) == 0 {
+		returningSQL = databaseContext.dialect().InsertReturningID(insertSQL, databaseContext.quoteIdentifier(entityDescription.PrimaryKey))
+	}
+	entityDescription.insertReturning = returningSQL != insertSQL
+
+	entityDescription.insertSQL = databaseContext.rebind(returningSQL)
+
+	entityDescription.InsertStatement, err = databaseContext.connection(entityDescription.Name, OpWrite).Prepare(entityDescription.insertSQL)
+
+	return err
+}
+
+// Entity Relationships
+
+func (entityDescription *EntityDescription) RegisterRelationship(entityRelationship EntityRelationship) {
+	if entityDescription.Relationships == nil {
+		entityDescription.Relationships = make(map[string]EntityRelationship)
+	}
+
+	entityDescription.Relationships[entityRelationship.EntityName] = entityRelationship
+}
+
+func (entityDescription *EntityDescription) RelationshipForName(entityName string) (entityRelationship EntityRelationship) {
+	return entityDescription.Relationships[entityName]
+}
+
+// Entity Creation
+
+func (entityDescription *EntityDescription) Create(transaction *sql.Tx, args ...interface{}) (entity Entity, err error) {
+	return entityDescription.CreateContext(context.Background(), transaction, args...)
+}
+
+// A transaction Create begins itself is bound to ctx, so cancelling ctx aborts
+// the statements in flight and rolls the whole create back.
+func (entityDescription *EntityDescription) CreateContext(ctx context.Context, transaction *sql.Tx, args ...interface{}) (entity Entity, err error) {
+	var (
+		keyValues    []interface{}
+		createResult CreateResult
+	)
+
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	keyValues, err = entityDescription.primaryKeyValues(entityDescription.insertColumns(), args)
+	if err != nil {
+		return nil, err
 	}
 
+	createResult, err = entityDescription.create(ctx, contextTransaction(ctx, transaction), entityDescription.preparedInsert(args), keyValues, true)
+
+	return createResult.Entity, err
+}
+
+// Like Create, but skips reading the new row back, so the result carries only
+// the ID and creation time.
+func (entityDescription *EntityDescription) CreateWithResult(transaction *sql.Tx, args ...interface{}) (createResult CreateResult, err error) {
+	var (
+		keyValues []interface{}
+	)
+
+	keyValues, err = entityDescription.primaryKeyValues(entityDescription.insertColumns(), args)
 	if err != nil {
-		goto cleanup
+		return CreateResult{}, err
 	}
 
-	entities, err = entityDescription.CreateFromRows(rows)
+	return entityDescription.create(context.Background(), transaction, entityDescription.preparedInsert(args), keyValues, false)
+}
+
+func (entityDescription *EntityDescription) CreateNamed(transaction *sql.Tx, values map[string]interface{}) (entity Entity, err error) {
+	return entityDescription.CreateNamedContext(context.Background(), transaction, values)
+}
+
+// Generated columns are dropped from values, since the database computes them.
+func (entityDescription *EntityDescription) CreateNamedContext(ctx context.Context, transaction *sql.Tx, values map[string]interface{}) (entity Entity, err error) {
+	var (
+		insert       func(context.Context, *sql.Tx) (int64, error)
+		keyValues    []interface{}
+		createResult CreateResult
+	)
+
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
 
+	insert, keyValues, err = entityDescription.namedInsert(values)
 	if err != nil {
-		goto cleanup
+		return nil, err
 	}
 
-cleanup:
-	defer rows.Close()
+	createResult, err = entityDescription.create(ctx, contextTransaction(ctx, transaction), insert, keyValues, true)
 
-	return entities, err
+	return createResult.Entity, err
 }
 
-func (entityDescription *EntityDescription) FindRelatedEntity(transaction *sql.Tx, targetEntityName string, queryKey string, queryValue interface{}) (entities []Entity, err error) {
+func (entityDescription *EntityDescription) CreateNamedWithResult(transaction *sql.Tx, values map[string]interface{}) (createResult CreateResult, err error) {
 	var (
-		relationship            EntityRelationship
-		targetEntityDescription EntityDescription
-		joinTableName           string
-		targetTableName         string
-		joinTableForeignKey     string
-		targetTableKey          string
-		selectStatement         string
-		rows                    *sql.Rows
+		insert    func(context.Context, *sql.Tx) (int64, error)
+		keyValues []interface{}
 	)
 
-	relationship = entityDescription.RelationshipForName(targetEntityName)
-	targetEntityDescription = entityDescription.Context.EntityDescriptionForName(targetEntityName)
+	insert, keyValues, err = entityDescription.namedInsert(values)
+	if err != nil {
+		return CreateResult{}, err
+	}
 
-	joinTableName = relationship.JoinTableName
-	targetTableName = targetEntityDescription.TableName
+	return entityDescription.create(context.Background(), transaction, insert, keyValues, false)
+}
 
-	joinTableForeignKey = relationship.ForeignKey
-	targetTableKey = relationship.TargetKey
+// Arguments are matched to the declared insert columns for ZeroAsNull when
+// there is one per column.
+func (entityDescription *EntityDescription) preparedInsert(args []interface{}) func(context.Context, *sql.Tx) (int64, error) {
+	columnNames := entityDescription.insertColumns()
+	if len(columnNames) == len(args) {
+		args = entityDescription.bindValues(columnNames, args)
+	}
 
-	// SELECT * FROM lists_placemarks LEFT OUTER JOIN placemarks ON lists_placemarks.placemarksID=placemarks.id WHERE lists_placemarks.listsID=1
-	selectStatement = fmt.Sprintf("SELECT %s.* FROM %s LEFT OUTER JOIN %s ON %s.%s=%s.%s WHERE %s.%s=?", targetTableName, joinTableName, targetTableName, joinTableName, joinTableForeignKey, targetTableName, targetTableKey, joinTableName, queryKey)
+	return func(ctx context.Context, transaction *sql.Tx) (objectID int64, err error) {
+		if entityDescription.InsertStatement == nil {
+			return 0, ErrNoInsertStatement
+		}
 
-	if transaction != nil {
-		rows, err = transaction.Query(selectStatement, queryValue)
-	} else {
-		rows, err = entityDescription.Context.Database.Query(selectStatement, queryValue)
+		err = entityDescription.runBeforeCreate(args)
+		if err != nil {
+			return 0, err
+		}
+
+		insertStatement := transaction.StmtContext(ctx, entityDescription.InsertStatement)
+		defer insertStatement.Close()
+
+		startTime := time.Now()
+		defer func() {
+			entityDescription.Context.recordQuery(ctx, entityDescription.insertSQL, args, time.Since(startTime), err)
+		}()
+
+		if entityDescription.insertReturning {
+			err = insertStatement.QueryRowContext(ctx, args...).Scan(&objectID)
+			return objectID, err
+		}
+
+		result, err := insertStatement.ExecContext(ctx, args...)
+		if err != nil || len(entityDescription.PrimaryKeys) > 0 {
+			return 0, err
+		}
+
+		return result.LastInsertId()
+	}
+}
+
+func (entityDescription *EntityDescription) namedInsert(values map[string]interface{}) (insert func(context.Context, *sql.Tx) (int64, error), keyValues []interface{}, err error) {
+	var (
+		databaseContext *DatabaseContext
+		columnNames     []string
+		placeholders    []string
+		args            []interface{}
+		insertSQL       string
+	)
+
+	databaseContext = entityDescription.Context
+
+	for columnName := range values {
+		if !entityDescription.isGeneratedColumn(columnName) {
+			columnNames = append(columnNames, columnName)
+		}
 	}
 
-	entities, err = targetEntityDescription.CreateFromRows(rows)
+	if len(columnNames) == 0 {
+		return nil, nil, ErrNoInsertColumns
+	}
 
-	return entities, err
+	err = entityDescription.checkWritableColumns(columnNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(columnNames)
+
+	for _, columnName := range columnNames {
+		placeholders = append(placeholders, "?")
+		args = append(args, values[columnName])
+	}
+
+	keyValues, err = entityDescription.primaryKeyValues(columnNames, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	args = entityDescription.bindValues(columnNames, args)
+
+	insertSQL = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", databaseContext.quoteIdentifier(entityDescription.TableName), strings.Join(databaseContext.quoteIdentifiers(columnNames), ", "), strings.Join(placeholders, ", "))
+
+	return func(ctx context.Context, transaction *sql.Tx) (int64, error) {
+		err := entityDescription.runBeforeCreate(args)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(entityDescription.PrimaryKeys) > 0 {
+			_, err = databaseContext.exec(ctx, transaction, entityDescription.Name, insertSQL, args...)
+			return 0, err
+		}
+
+		return databaseContext.insertID(ctx, transaction, entityDescription.Name, entityDescription.PrimaryKey, insertSQL, args...)
+	}, keyValues, nil
+}
+
+// The new row is read back into an entity only when readBack is set, and
+// AfterCreate is handed nil otherwise. BeforeCreate runs inside insert, with
+// the arguments it binds. The row is found again by keyValues when the entity
+// has a composite key, and by the PrimaryKey value insert returns otherwise.
+func (entityDescription *EntityDescription) create(ctx context.Context, transaction *sql.Tx, insert func(context.Context, *sql.Tx) (int64, error), keyValues []interface{}, readBack bool) (createResult CreateResult, err error) {
+	var (
+		databaseContext      *DatabaseContext
+		commitAtEnd          bool
+		objectID             int64
+		keyClause            *WhereClause
+		keySQL               string
+		keyArgs              []interface{}
+		createdTime          int64
+		tableName            string
+		updateCreatedDateSQL string
+	)
+
+	databaseContext = entityDescription.Context
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return CreateResult{}, err
+	}
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(ctx, entityDescription.Name, OpWrite)
+		if err != nil {
+			return CreateResult{}, err
+		}
+
+		commitAtEnd = true
+	}
+
+	objectID, err = insert(ctx, transaction)
+	if err != nil {
+		goto cleanup
+	}
+
+	tableName = databaseContext.quoteIdentifier(entityDescription.TableName)
+
+	createResult.ID = objectID
+
+	if keyValues != nil {
+		keyClause, err = entityDescription.primaryKeyClause(keyValues)
+		if err != nil {
+			goto cleanup
+		}
+	} else {
+		keyClause = Where(entityDescription.PrimaryKey, "=", objectID)
+	}
+
+	if entityDescription.CreatedDateColumn != "" {
+		keySQL, keyArgs, err = keyClause.build(databaseContext, "")
+		if err != nil {
+			goto cleanup
+		}
+
+		createdTime = time.Now().Unix()
+		updateCreatedDateSQL = fmt.Sprintf("UPDATE %s SET %s=? WHERE %s", tableName, databaseContext.quoteIdentifier(entityDescription.CreatedDateColumn), keySQL)
+
+		_, err = databaseContext.exec(ctx, transaction, entityDescription.Name, updateCreatedDateSQL, append([]interface{}{createdTime}, keyArgs...)...)
+		if err != nil {
+			goto cleanup
+		}
+
+		createResult.CreatedAt = time.Unix(createdTime, 0)
+	}
+
+	if !readBack {
+		err = entityDescription.runAfterCreate(nil)
+		goto cleanup
+	}
+
+	createResult.Entity, err = entityDescription.reselectWhere(ctx, transaction, keyClause)
+	if err != nil {
+		goto cleanup
+	}
+
+	IdentityMapFromContext(ctx).refresh(entityDescription.Name, []Entity{createResult.Entity})
+
+	err = entityDescription.runAfterCreate(createResult.Entity)
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	return createResult, err
+}
+
+// Inserts rows, one value per declared insert column in order, with
+// multi-row INSERTs of up to BatchSize rows, fewer when the dialect's
+// parameter limit demands it. Every row is stamped with the same
+// CreatedDateColumn time and the created entities come back in the order of
+// rows. Each chunk runs under a savepoint; when one fails, its rows are
+// retried one at a time to find the culprit, which is reported as a
+// *BatchResult. A transaction CreateMany began itself is then rolled back
+// whole, but in a transaction the caller passed the rows before the culprit
+// stay applied. Where the dialect has no RETURNING, the new keys are worked out
+// from LastInsertId, so the table needs an auto-increment PrimaryKey handing
+// out consecutive values. Entities with PrimaryKeys have no such key, and are
+// refused with ErrKeyMismatch.
+func (entityDescription *EntityDescription) CreateMany(transaction *sql.Tx, rows [][]interface{}) (entities []Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		columnNames     []string
+		rowsPerChunk    int
+		createdTime     int64
+		chunkIDs        []interface{}
+		chunkEntities   []Entity
+		failedOffset    int
+	)
+
+	databaseContext = entityDescription.Context
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entityDescription.PrimaryKeys) > 0 {
+		return nil, fmt.Errorf("%w: %s has a composite key", ErrKeyMismatch, entityDescription.Name)
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columnNames = entityDescription.insertColumns()
+	if len(columnNames) == 0 {
+		return nil, ErrNoInsertColumns
+	}
+
+	for index, row := range rows {
+		if len(row) != len(columnNames) {
+			return nil, fmt.Errorf("%w: row %d has %d values for %d columns", ErrRowWidth, index, len(row), len(columnNames))
+		}
+	}
+
+	rowsPerChunk = entityDescription.BatchSize
+	if rowsPerChunk <= 0 {
+		rowsPerChunk = DefaultBatchSize
+	}
+	if rowsPerChunk > databaseContext.parameterChunkSize()/len(columnNames) {
+		rowsPerChunk = databaseContext.parameterChunkSize() / len(columnNames)
+	}
+	if rowsPerChunk < 1 {
+		rowsPerChunk = 1
+	}
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpWrite)
+		if err != nil {
+			return nil, err
+		}
+
+		commitAtEnd = true
+	}
+
+	createdTime = time.Now().Unix()
+
+	for start := 0; start < len(rows); start += rowsPerChunk {
+		end := start + rowsPerChunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		chunkIDs, failedOffset, err = entityDescription.createIsolated(transaction, columnNames, rows[start:end], createdTime)
+		if err != nil {
+			if failedOffset >= 0 {
+				err = &BatchResult{SuccessCount: start + failedOffset, FailedIndex: start + failedOffset, Err: err}
+			}
+
+			goto cleanup
+		}
+
+		chunkEntities, err = entityDescription.reselectMany(transaction, chunkIDs)
+		if err != nil {
+			goto cleanup
+		}
+
+		for _, entity := range chunkEntities {
+			err = entityDescription.runAfterCreate(entity)
+			if err != nil {
+				goto cleanup
+			}
+		}
+
+		entities = append(entities, chunkEntities...)
+	}
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entities, nil
+}
+
+// failedOffset is the offending row's position in rows, or -1 when the error
+// is not down to one row, as in upsertIsolated.
+func (entityDescription *EntityDescription) createIsolated(transaction *sql.Tx, columnNames []string, rows [][]interface{}, createdTime int64) (ids []interface{}, failedOffset int, err error) {
+	var (
+		rowIDs       []interface{}
+		statementErr error
+	)
+
+	ids, statementErr, err = entityDescription.createSavepoint(transaction, columnNames, rows, createdTime)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if statementErr == nil {
+		return ids, -1, nil
+	}
+
+	if len(rows) == 1 {
+		return nil, 0, statementErr
+	}
+
+	ids = nil
+
+	for offset := range rows {
+		rowIDs, statementErr, err = entityDescription.createSavepoint(transaction, columnNames, rows[offset:offset+1], createdTime)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		if statementErr != nil {
+			return nil, offset, statementErr
+		}
+
+		ids = append(ids, rowIDs...)
+	}
+
+	return ids, -1, nil
+}
+
+// A failed chunk is rolled back to the savepoint and returned as
+// statementErr, leaving the transaction usable; err is a failure of the
+// savepoint itself.
+func (entityDescription *EntityDescription) createSavepoint(transaction *sql.Tx, columnNames []string, rows [][]interface{}, createdTime int64) (ids []interface{}, statementErr error, err error) {
+	var (
+		databaseContext *DatabaseContext
+	)
+
+	databaseContext = entityDescription.Context
+
+	_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, "SAVEPOINT "+batchSavepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids, statementErr = entityDescription.insertChunk(transaction, columnNames, rows, createdTime)
+	if statementErr != nil {
+		ids = nil
+
+		_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, "ROLLBACK TO SAVEPOINT "+batchSavepoint)
+		if err != nil {
+			return nil, statementErr, err
+		}
+	}
+
+	_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, "RELEASE SAVEPOINT "+batchSavepoint)
+
+	return ids, statementErr, err
+}
+
+// Inserts one chunk, stamps its CreatedDateColumn and returns the new IDs in
+// row order.
+func (entityDescription *EntityDescription) insertChunk(transaction *sql.Tx, columnNames []string, rows [][]interface{}, createdTime int64) (ids []interface{}, err error) {
+	var (
+		databaseContext      *DatabaseContext
+		tableName            string
+		rowSQL               string
+		valuesSQL            []string
+		args                 []interface{}
+		insertSQL            string
+		updateCreatedDateSQL string
+	)
+
+	databaseContext = entityDescription.Context
+	tableName = databaseContext.quoteIdentifier(entityDescription.TableName)
+
+	rowSQL = "(" + placeholderList(len(columnNames)) + ")"
+	for _, row := range rows {
+		boundRow := entityDescription.bindValues(columnNames, row)
+
+		err = entityDescription.runBeforeCreate(boundRow)
+		if err != nil {
+			return nil, err
+		}
+
+		valuesSQL = append(valuesSQL, rowSQL)
+		args = append(args, boundRow...)
+	}
+
+	insertSQL = fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", tableName, strings.Join(databaseContext.quoteIdentifiers(columnNames), ", "), strings.Join(valuesSQL, ", "))
+
+	ids, err = databaseContext.insertIDs(context.Background(), transaction, entityDescription.Name, entityDescription.PrimaryKey, insertSQL, len(rows), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if entityDescription.CreatedDateColumn == "" {
+		return ids, nil
+	}
+
+	updateCreatedDateSQL = fmt.Sprintf("UPDATE %s SET %s=? WHERE %s IN (%s)", tableName, databaseContext.quoteIdentifier(entityDescription.CreatedDateColumn), databaseContext.quoteIdentifier(entityDescription.PrimaryKey), placeholderList(len(ids)))
+
+	_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, updateCreatedDateSQL, append([]interface{}{createdTime}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (entityDescription *EntityDescription) reselectMany(transaction *sql.Tx, ids []interface{}) (entities []Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		idColumn        string
+		selectColumns   string
+		querySQL        string
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+	idColumn = databaseContext.quoteIdentifier(entityDescription.TableName + "." + entityDescription.PrimaryKey)
+
+	selectColumns, err = entityDescription.selectColumns("")
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, err = entityDescription.readSQL(selectColumns, fmt.Sprintf(" WHERE %s IN (%s)", idColumn, placeholderList(len(ids))))
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL += " ORDER BY " + idColumn
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpWrite, querySQL, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return entityDescription.CreateFromRows(rows)
+}
+
+// Reads a row just written back through the entity's read path, inside the
+// writing transaction.
+func (entityDescription *EntityDescription) reselectWhere(ctx context.Context, transaction *sql.Tx, whereClause *WhereClause) (entity Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		selectColumns   string
+		whereSQL        string
+		args            []interface{}
+		querySQL        string
+		rows            *sql.Rows
+		scanSuccess     bool
+	)
+
+	databaseContext = entityDescription.Context
+
+	selectColumns, err = entityDescription.selectColumns("")
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, entityDescription.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL, err = entityDescription.readSQL(selectColumns, whereSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = databaseContext.query(ctx, transaction, entityDescription.Name, OpWrite, querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entity = entityDescription.CreateZeroInstance()
+
+	scanSuccess, err = entity.ScanFromRow(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if !scanSuccess {
+		return nil, ErrNotFound
+	}
+
+	return entity, nil
+}
+
+// Entity Update
+
+func (entityDescription *EntityDescription) Update(transaction *sql.Tx, id interface{}, fields map[string]interface{}) (entity Entity, err error) {
+	return entityDescription.UpdateContext(context.Background(), transaction, id, fields)
+}
+
+// Sets fields on the row whose primary key is id, one value per column when
+// PrimaryKeys is set, and returns the row as it now reads. Columns are written
+// in sorted order; generated columns are dropped, as in CreateNamed.
+// UpdatedDateColumn, when set, is stamped with the current Unix time in the
+// same statement, in place of any value in fields. A row that does not exist
+// is ErrNotFound.
+func (entityDescription *EntityDescription) UpdateContext(ctx context.Context, transaction *sql.Tx, id interface{}, fields map[string]interface{}) (entity Entity, err error) {
+	var (
+		updateResult UpdateResult
+	)
+
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	updateResult, err = entityDescription.update(ctx, contextTransaction(ctx, transaction), id, fields, true)
+
+	return updateResult.Entity, err
+}
+
+// Like Update, but skips reading the row back, so the result carries only the
+// UpdatedDateColumn time, and a row that does not exist goes unnoticed.
+func (entityDescription *EntityDescription) UpdateWithResult(transaction *sql.Tx, id interface{}, fields map[string]interface{}) (updateResult UpdateResult, err error) {
+	return entityDescription.update(context.Background(), transaction, id, fields, false)
+}
+
+// The row is read back into an entity only when readBack is set, and
+// AfterUpdate is handed nil otherwise.
+func (entityDescription *EntityDescription) update(ctx context.Context, transaction *sql.Tx, id interface{}, fields map[string]interface{}, readBack bool) (updateResult UpdateResult, err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		columnNames     []string
+		assignments     []string
+		args            []interface{}
+		keyClause       *WhereClause
+		keySQL          string
+		keyArgs         []interface{}
+		updatedTime     int64
+		updateSQL       string
+	)
+
+	databaseContext = entityDescription.Context
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return UpdateResult{}, err
+	}
+
+	for columnName := range fields {
+		if !entityDescription.isGeneratedColumn(columnName) && !strings.EqualFold(columnName, entityDescription.UpdatedDateColumn) {
+			columnNames = append(columnNames, columnName)
+		}
+	}
+
+	if len(columnNames) == 0 {
+		return UpdateResult{}, ErrNoUpdateColumns
+	}
+
+	err = entityDescription.checkWritableColumns(columnNames)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+
+	sort.Strings(columnNames)
+
+	for _, columnName := range columnNames {
+		assignments = append(assignments, databaseContext.quoteIdentifier(columnName)+"=?")
+		args = append(args, fields[columnName])
+	}
+
+	if entityDescription.UpdatedDateColumn != "" {
+		updatedTime = time.Now().Unix()
+		assignments = append(assignments, databaseContext.quoteIdentifier(entityDescription.UpdatedDateColumn)+"=?")
+		args = append(args, updatedTime)
+	}
+
+	keyClause, err = entityDescription.primaryKeyClause(id)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+
+	keySQL, keyArgs, err = keyClause.build(databaseContext, "")
+	if err != nil {
+		return UpdateResult{}, err
+	}
+
+	updateSQL = fmt.Sprintf("UPDATE %s SET %s WHERE %s", databaseContext.quoteIdentifier(entityDescription.TableName), strings.Join(assignments, ", "), keySQL)
+	args = append(args, keyArgs...)
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(ctx, entityDescription.Name, OpWrite)
+		if err != nil {
+			return UpdateResult{}, err
+		}
+
+		commitAtEnd = true
+	}
+
+	err = entityDescription.runBeforeUpdate(id, fields)
+	if err != nil {
+		goto cleanup
+	}
+
+	_, err = databaseContext.exec(ctx, transaction, entityDescription.Name, updateSQL, args...)
+	if err != nil {
+		goto cleanup
+	}
+
+	if updatedTime != 0 {
+		updateResult.UpdatedAt = time.Unix(updatedTime, 0)
+	}
+
+	if !readBack {
+		IdentityMapFromContext(ctx).evict(entityDescription.Name, id)
+
+		err = entityDescription.runAfterUpdate(nil)
+		goto cleanup
+	}
+
+	updateResult.Entity, err = entityDescription.reselectWhere(ctx, transaction, keyClause)
+	if err != nil {
+		goto cleanup
+	}
+
+	IdentityMapFromContext(ctx).refresh(entityDescription.Name, []Entity{updateResult.Entity})
+
+	err = entityDescription.runAfterUpdate(updateResult.Entity)
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	if err != nil {
+		return UpdateResult{}, err
+	}
+
+	return updateResult, nil
+}
+
+func (entityDescription *EntityDescription) CreateFromRows(rows *sql.Rows) (entities []Entity, err error) {
+	var (
+		scanSuccess bool
+	)
+
+	for {
+		entity := entityDescription.CreateZeroInstance()
+
+		scanSuccess, err = entity.ScanFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if !scanSuccess {
+			break
+		}
+
+		entities = append(entities, entity)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return entities, nil
+}
+
+// Entity Deletion
+
+func (entityDescription *EntityDescription) Delete(transaction *sql.Tx, id interface{}) (err error) {
+	return entityDescription.DeleteContext(context.Background(), transaction, id)
+}
+
+// The id holds one value per column when PrimaryKeys is set. With a
+// SoftDeleteColumn the row is kept and the column stamped with the current
+// Unix time instead, which hides it from the finders. Deleting a row that does
+// not exist, or is already soft-deleted, is ErrNotFound. A hard delete also
+// removes the join rows that point at the row through relationships with a
+// CounterColumn, and takes them off the sources' counters; a soft delete
+// leaves both alone.
+func (entityDescription *EntityDescription) DeleteContext(ctx context.Context, transaction *sql.Tx, id interface{}) (err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		tableName       string
+		keyClause       *WhereClause
+		keySQL          string
+		keyArgs         []interface{}
+		deleteSQL       string
+		args            []interface{}
+		result          sql.Result
+		deletedCount    int64
+	)
+
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	databaseContext = entityDescription.Context
+	transaction = contextTransaction(ctx, transaction)
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	tableName = databaseContext.quoteIdentifier(entityDescription.TableName)
+
+	keyClause, err = entityDescription.primaryKeyClause(id)
+	if err != nil {
+		return err
+	}
+
+	keySQL, keyArgs, err = keyClause.build(databaseContext, "")
+	if err != nil {
+		return err
+	}
+
+	if entityDescription.SoftDeleteColumn != "" {
+		softDeleteColumn := databaseContext.quoteIdentifier(entityDescription.SoftDeleteColumn)
+
+		deleteSQL = fmt.Sprintf("UPDATE %s SET %s=? WHERE %s AND %s IS NULL", tableName, softDeleteColumn, keySQL, softDeleteColumn)
+		args = append([]interface{}{time.Now().Unix()}, keyArgs...)
+	} else {
+		deleteSQL = fmt.Sprintf("DELETE FROM %s WHERE %s", tableName, keySQL)
+		args = keyArgs
+	}
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(ctx, entityDescription.Name, OpWrite)
+		if err != nil {
+			return err
+		}
+
+		commitAtEnd = true
+	}
+
+	err = entityDescription.runBeforeDelete(id)
+	if err != nil {
+		goto cleanup
+	}
+
+	if entityDescription.SoftDeleteColumn == "" {
+		err = entityDescription.detachCounted(ctx, transaction, keySQL, keyArgs)
+		if err != nil {
+			goto cleanup
+		}
+	}
+
+	result, err = databaseContext.exec(ctx, transaction, entityDescription.Name, deleteSQL, args...)
+	if err != nil {
+		goto cleanup
+	}
+
+	deletedCount, err = result.RowsAffected()
+	if err != nil {
+		goto cleanup
+	}
+
+	if deletedCount == 0 {
+		err = ErrNotFound
+		goto cleanup
+	}
+
+	IdentityMapFromContext(ctx).evict(entityDescription.Name, id)
+
+	err = entityDescription.runAfterDelete(id)
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	return err
+}
+
+// Every registered ManyToMany relationship onto this entity that keeps a
+// CounterColumn loses its join rows to the row matching keySQL, and each
+// source's counter drops by the rows it lost.
+func (entityDescription *EntityDescription) detachCounted(ctx context.Context, transaction *sql.Tx, keySQL string, keyArgs []interface{}) (err error) {
+	var (
+		databaseContext *DatabaseContext
+	)
+
+	databaseContext = entityDescription.Context
+
+	for _, sourceEntityDescription := range databaseContext.registeredEntityDescriptions() {
+		for _, relationship := range sourceEntityDescription.Relationships {
+			if relationship.EntityName != entityDescription.Name || relationship.kind() != ManyToMany || relationship.CounterColumn == "" {
+				continue
+			}
+
+			var (
+				targetKey       string
+				targetSQL       string
+				countStatement  string
+				deleteStatement string
+				rows            *sql.Rows
+				sourceKeys      []interface{}
+				detachedCounts  []int64
+			)
+
+			targetKey = relationship.TargetKey
+			if targetKey == "" {
+				targetKey = entityDescription.PrimaryKey
+			}
+
+			targetSQL = fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s)", databaseContext.quoteIdentifier(relationship.ForeignKey), databaseContext.quoteIdentifier(targetKey), databaseContext.quoteIdentifier(entityDescription.TableName), keySQL)
+			countStatement = fmt.Sprintf("SELECT %s, COUNT(*) FROM %s WHERE %s GROUP BY %s", databaseContext.quoteIdentifier(relationship.SourceKey), databaseContext.quoteIdentifier(relationship.JoinTableName), targetSQL, databaseContext.quoteIdentifier(relationship.SourceKey))
+
+			rows, err = databaseContext.query(ctx, transaction, entityDescription.Name, OpWrite, countStatement, keyArgs...)
+			if err != nil {
+				return err
+			}
+
+			for rows.Next() {
+				var (
+					sourceKey     interface{}
+					detachedCount int64
+				)
+
+				err = rows.Scan(&sourceKey, &detachedCount)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+
+				sourceKeys = append(sourceKeys, sourceKey)
+				detachedCounts = append(detachedCounts, detachedCount)
+			}
+
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return err
+			}
+
+			deleteStatement = fmt.Sprintf("DELETE FROM %s WHERE %s", databaseContext.quoteIdentifier(relationship.JoinTableName), targetSQL)
+
+			_, err = databaseContext.exec(ctx, transaction, entityDescription.Name, deleteStatement, keyArgs...)
+			if err != nil {
+				return err
+			}
+
+			for index, sourceKey := range sourceKeys {
+				err = sourceEntityDescription.adjustCounter(transaction, relationship, sourceKey, -detachedCounts[index])
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Entity Relationship Management
+
+// Inserts one join row per target key, in chunks that keep each INSERT under
+// the dialect's parameter limit, inside one transaction. The counter is
+// adjusted once with the total.
+func (entityDescription *EntityDescription) AttachMany(transaction *sql.Tx, relationshipName string, sourceKey interface{}, targetKeys []interface{}) (err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		relationship    EntityRelationship
+		result          sql.Result
+		attachedCount   int64
+		chunkAttached   int64
+	)
+
+	if len(targetKeys) == 0 {
+		return nil
+	}
+
+	databaseContext = entityDescription.Context
+
+	relationship, err = entityDescription.joinRelationship(relationshipName)
+	if err != nil {
+		return err
+	}
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpWrite)
+		if err != nil {
+			return err
+		}
+
+		commitAtEnd = true
+	}
+
+	for _, targetChunk := range chunkValues(targetKeys, databaseContext.parameterChunkSize()/2) {
+		var (
+			valuesSQL []string
+			args      []interface{}
+		)
+
+		for _, targetKey := range targetChunk {
+			valuesSQL = append(valuesSQL, "(?, ?)")
+			args = append(args, sourceKey, targetKey)
+		}
+
+		insertStatement := fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES %s", databaseContext.quoteIdentifier(relationship.JoinTableName), databaseContext.quoteIdentifier(relationship.SourceKey), databaseContext.quoteIdentifier(relationship.ForeignKey), strings.Join(valuesSQL, ", "))
+
+		result, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, insertStatement, args...)
+		if err != nil {
+			goto cleanup
+		}
+
+		chunkAttached, err = result.RowsAffected()
+		if err != nil {
+			chunkAttached, err = int64(len(targetChunk)), nil
+		}
+
+		attachedCount += chunkAttached
+	}
+
+	err = entityDescription.adjustCounter(transaction, relationship, sourceKey, attachedCount)
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	return err
+}
+
+func (entityDescription *EntityDescription) DetachAll(transaction *sql.Tx, relationshipName string, sourceKeyValue interface{}) (detachedCount int64, err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		relationship    EntityRelationship
+		deleteStatement string
+		result          sql.Result
+	)
+
+	databaseContext = entityDescription.Context
+
+	relationship, err = entityDescription.joinRelationship(relationshipName)
+	if err != nil {
+		return 0, err
+	}
+
+	deleteStatement = fmt.Sprintf("DELETE FROM %s WHERE %s=?", databaseContext.quoteIdentifier(relationship.JoinTableName), databaseContext.quoteIdentifier(relationship.SourceKey))
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpWrite)
+		if err != nil {
+			return 0, err
+		}
+
+		commitAtEnd = true
+	}
+
+	result, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, deleteStatement, sourceKeyValue)
+	if err != nil {
+		goto cleanup
+	}
+
+	detachedCount, err = result.RowsAffected()
+	if err != nil {
+		goto cleanup
+	}
+
+	err = entityDescription.adjustCounter(transaction, relationship, sourceKeyValue, -detachedCount)
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return detachedCount, nil
+}
+
+// Inserts one join row. Nothing checks for an existing row first: a join
+// table with a unique key over both columns fails with the driver's error, and
+// one without gets a duplicate row. SetAssociation is the idempotent form.
+func (entityDescription *EntityDescription) AddRelation(transaction *sql.Tx, relationshipName string, sourceKey interface{}, targetKey interface{}) (err error) {
+	return entityDescription.AttachMany(transaction, relationshipName, sourceKey, []interface{}{targetKey})
+}
+
+// Deletes the join row between sourceKey and targetKey. Removing a row that is
+// not there is not an error.
+func (entityDescription *EntityDescription) RemoveRelation(transaction *sql.Tx, relationshipName string, sourceKey interface{}, targetKey interface{}) (err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		relationship    EntityRelationship
+		deleteStatement string
+		result          sql.Result
+		removedCount    int64
+	)
+
+	databaseContext = entityDescription.Context
+
+	relationship, err = entityDescription.joinRelationship(relationshipName)
+	if err != nil {
+		return err
+	}
+
+	deleteStatement = fmt.Sprintf("DELETE FROM %s WHERE %s=? AND %s=?", databaseContext.quoteIdentifier(relationship.JoinTableName), databaseContext.quoteIdentifier(relationship.SourceKey), databaseContext.quoteIdentifier(relationship.ForeignKey))
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpWrite)
+		if err != nil {
+			return err
+		}
+
+		commitAtEnd = true
+	}
+
+	result, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, deleteStatement, sourceKey, targetKey)
+	if err != nil {
+		goto cleanup
+	}
+
+	removedCount, err = result.RowsAffected()
+	if err != nil {
+		goto cleanup
+	}
+
+	err = entityDescription.adjustCounter(transaction, relationship, sourceKey, -removedCount)
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	return err
+}
+
+// Makes sourceKey's join rows exactly targetKeys, deleting and inserting only
+// the rows that differ, inside one transaction, and moves CounterColumn by
+// the net change. Duplicate target keys count once.
+func (entityDescription *EntityDescription) SetAssociation(transaction *sql.Tx, relationshipName string, sourceKey interface{}, targetKeys []interface{}) (err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		relationship    EntityRelationship
+		joinRows        [][2]interface{}
+		currentKeys     map[string]bool
+		desiredKeys     map[string]bool
+		removedKeys     []interface{}
+		addedKeys       []interface{}
+		chunkSize       int
+		deleteStatement string
+		result          sql.Result
+		removedCount    int64
+		chunkRemoved    int64
+	)
+
+	databaseContext = entityDescription.Context
+
+	relationship, err = entityDescription.joinRelationship(relationshipName)
+	if err != nil {
+		return err
+	}
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpWrite)
+		if err != nil {
+			return err
+		}
+
+		commitAtEnd = true
+	}
+
+	joinRows, err = entityDescription.queryJoinRows(context.Background(), transaction, relationship, []interface{}{sourceKey})
+	if err != nil {
+		goto cleanup
+	}
+
+	desiredKeys = make(map[string]bool)
+	for _, targetKey := range targetKeys {
+		desiredKeys[keyString(targetKey)] = true
+	}
+
+	currentKeys = make(map[string]bool)
+	for _, joinRow := range joinRows {
+		currentKeys[keyString(joinRow[1])] = true
+
+		if !desiredKeys[keyString(joinRow[1])] {
+			removedKeys = append(removedKeys, joinRow[1])
+		}
+	}
+
+	for _, targetKey := range targetKeys {
+		if !currentKeys[keyString(targetKey)] {
+			currentKeys[keyString(targetKey)] = true
+			addedKeys = append(addedKeys, targetKey)
+		}
+	}
+
+	chunkSize = databaseContext.parameterChunkSize() - 1
+
+	for _, removedChunk := range chunkValues(removedKeys, chunkSize) {
+		deleteStatement = fmt.Sprintf("DELETE FROM %s WHERE %s=? AND %s IN (%s)", databaseContext.quoteIdentifier(relationship.JoinTableName), databaseContext.quoteIdentifier(relationship.SourceKey), databaseContext.quoteIdentifier(relationship.ForeignKey), placeholderList(len(removedChunk)))
+
+		result, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, deleteStatement, append([]interface{}{sourceKey}, removedChunk...)...)
+		if err != nil {
+			goto cleanup
+		}
+
+		chunkRemoved, err = result.RowsAffected()
+		if err != nil {
+			goto cleanup
+		}
+
+		removedCount += chunkRemoved
+	}
+
+	err = entityDescription.adjustCounter(transaction, relationship, sourceKey, -removedCount)
+	if err != nil {
+		goto cleanup
+	}
+
+	for _, addedChunk := range chunkValues(addedKeys, chunkSize/2) {
+		err = entityDescription.AttachMany(transaction, relationshipName, sourceKey, addedChunk)
+		if err != nil {
+			goto cleanup
+		}
+	}
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	return err
+}
+
+// Keeps a relationship's CounterColumn on the source row in step with the join
+// rows, inside the same transaction as the change that moved it.
+func (entityDescription *EntityDescription) adjustCounter(transaction *sql.Tx, relationship EntityRelationship, sourceKey interface{}, delta int64) (err error) {
+	var (
+		databaseContext *DatabaseContext
+		counterColumn   string
+		keyClause       *WhereClause
+		keySQL          string
+		keyArgs         []interface{}
+		updateStatement string
+	)
+
+	if relationship.CounterColumn == "" || delta == 0 {
+		return nil
+	}
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	databaseContext = entityDescription.Context
+	counterColumn = databaseContext.quoteIdentifier(relationship.CounterColumn)
+
+	keyClause, err = entityDescription.primaryKeyClause(sourceKey)
+	if err != nil {
+		return err
+	}
+
+	keySQL, keyArgs, err = keyClause.build(databaseContext, "")
+	if err != nil {
+		return err
+	}
+
+	updateStatement = fmt.Sprintf("UPDATE %s SET %s=COALESCE(%s, 0)+? WHERE %s", databaseContext.quoteIdentifier(entityDescription.TableName), counterColumn, counterColumn, keySQL)
+
+	_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, updateStatement, append([]interface{}{delta}, keyArgs...)...)
+
+	return err
+}
+
+func (entityDescription *EntityDescription) joinRelationship(relationshipName string) (relationship EntityRelationship, err error) {
+	relationship, ok := entityDescription.Relationships[relationshipName]
+	if !ok {
+		return relationship, fmt.Errorf("%w: %s", ErrUnknownRelationship, relationshipName)
+	}
+
+	if relationship.kind() != ManyToMany {
+		return relationship, fmt.Errorf("%w: %s", ErrNotJoinRelationship, relationshipName)
+	}
+
+	if relationship.SourceKey == "" {
+		return relationship, fmt.Errorf("%w: %s", ErrMissingSourceKey, relationshipName)
+	}
+
+	return relationship, nil
+}
+
+// Entity Find
+
+func (entityDescription *EntityDescription) FindEntity(transaction *sql.Tx, keyName *string, value interface{}) (entity Entity, err error) {
+	return entityDescription.FindEntityContext(context.Background(), transaction, keyName, value)
+}
+
+// With a nil keyName the entity is found by its primary key, and value holds
+// one value per column when PrimaryKeys is set. At most two rows are read: no
+// match is ErrNotFound and a second match ErrMultipleFound.
+func (entityDescription *EntityDescription) FindEntityContext(ctx context.Context, transaction *sql.Tx, keyName *string, value interface{}) (entity Entity, err error) {
+	var (
+		whereClause *WhereClause
+	)
+
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	if keyName == nil {
+		whereClause, err = entityDescription.primaryKeyClause(value)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		whereClause = Where(*keyName, "=", value)
+	}
+
+	return entityDescription.selectEntity(ctx, contextTransaction(ctx, transaction), whereClause, FindOptions{})
+}
+
+func (entityDescription *EntityDescription) FindEntityWithOptions(transaction *sql.Tx, clause *WhereClause, options FindOptions) (entity Entity, err error) {
+	return entityDescription.FindEntityWithOptionsContext(context.Background(), transaction, clause, options)
+}
+
+func (entityDescription *EntityDescription) FindEntityWithOptionsContext(ctx context.Context, transaction *sql.Tx, clause *WhereClause, options FindOptions) (entity Entity, err error) {
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	return entityDescription.selectEntity(ctx, contextTransaction(ctx, transaction), clause.clone(), options)
+}
+
+// Only as many rows are read as the match mode needs: one for FirstMatch, two
+// for StrictSingle so a second match can be detected. No match at all is
+// ErrNotFound.
+func (entityDescription *EntityDescription) selectEntity(ctx context.Context, transaction *sql.Tx, whereClause *WhereClause, options FindOptions) (entity Entity, err error) {
+	var (
+		limit    int
+		entities []Entity
+	)
+
+	limit = 2
+	if options.Match == FirstMatch {
+		limit = 1
+	}
+
+	entities, err = entityDescription.selectEntities(ctx, transaction, whereClause, options, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entities) == 0 {
+		return nil, ErrNotFound
+	}
+
+	if len(entities) > 1 {
+		return nil, ErrMultipleResults
+	}
+
+	return entities[0], nil
+}
+
+func (entityDescription *EntityDescription) FindEntities(transaction *sql.Tx, keyName *string, value interface{}) (entities []Entity, err error) {
+	return entityDescription.FindEntitiesContext(context.Background(), transaction, keyName, value)
+}
+
+func (entityDescription *EntityDescription) FindEntitiesContext(ctx context.Context, transaction *sql.Tx, keyName *string, value interface{}) (entities []Entity, err error) {
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	return entityDescription.findEntities(ctx, contextTransaction(ctx, transaction), keyName, value, 0, 0)
+}
+
+// Finds the rows whose keyName column holds any of values. Long value lists
+// are split across several queries to stay under the dialect's parameter
+// limit, so the rows come back grouped by query in no particular order. No
+// values means no rows, and no query.
+func (entityDescription *EntityDescription) FindEntitiesIn(transaction *sql.Tx, keyName *string, values []interface{}) (entities []Entity, err error) {
+	var (
+		columnName    string
+		chunkEntities []Entity
+	)
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	if keyName == nil {
+		columnName = entityDescription.PrimaryKey
+	} else {
+		columnName = *keyName
+	}
+
+	for _, valueChunk := range chunkValues(values, entityDescription.Context.parameterChunkSize()) {
+		chunkEntities, err = entityDescription.selectEntities(context.Background(), transaction, Where(columnName, "IN", valueChunk), FindOptions{}, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		entities = append(entities, chunkEntities...)
+	}
+
+	return entities, nil
+}
+
+// Pages through the rows matching keyName and value. A limit of zero or less
+// means no limit, and the offset is then ignored. Without an ordering the
+// database may return rows in any order from one page to the next.
+func (entityDescription *EntityDescription) FindEntitiesPaged(transaction *sql.Tx, keyName *string, value interface{}, limit int, offset int) (entities []Entity, err error) {
+	return entityDescription.findEntities(context.Background(), transaction, keyName, value, limit, offset)
+}
+
+func (entityDescription *EntityDescription) FindEntitiesOrdered(transaction *sql.Tx, keyName *string, value interface{}, orderBy string, ascending bool) (entities []Entity, err error) {
+	var (
+		columnName string
+	)
+
+	if keyName == nil {
+		columnName = entityDescription.PrimaryKey
+	} else {
+		columnName = *keyName
+	}
+
+	return entityDescription.selectEntities(context.Background(), transaction, Where(columnName, "=", value), FindOptions{OrderBy: []OrderBy{{Column: orderBy, Descending: !ascending}}}, 0, 0)
+}
+
+func (entityDescription *EntityDescription) FindEntitiesWithOptions(transaction *sql.Tx, clause *WhereClause, options FindOptions) (entities []Entity, err error) {
+	return entityDescription.FindEntitiesWithOptionsContext(context.Background(), transaction, clause, options)
+}
+
+func (entityDescription *EntityDescription) FindEntitiesWithOptionsContext(ctx context.Context, transaction *sql.Tx, clause *WhereClause, options FindOptions) (entities []Entity, err error) {
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	return entityDescription.selectEntities(ctx, contextTransaction(ctx, transaction), clause.clone(), options, 0, 0)
+}
+
+func (entityDescription *EntityDescription) findEntities(ctx context.Context, transaction *sql.Tx, keyName *string, value interface{}, limit int, offset int) (entities []Entity, err error) {
+	var (
+		columnName string
+	)
+
+	if keyName == nil {
+		columnName = entityDescription.PrimaryKey
+	} else {
+		columnName = *keyName
+	}
+
+	return entityDescription.selectEntities(ctx, transaction, Where(columnName, "=", value), FindOptions{}, limit, offset)
+}
+
+// A limit of zero or less selects every matching row, and offset is only
+// applied under a limit. whereClause is passed to BeforeFind as is, so callers
+// hand in a clause they own.
+func (entityDescription *EntityDescription) selectEntities(ctx context.Context, transaction *sql.Tx, whereClause *WhereClause, options FindOptions, limit int, offset int) (entities []Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereSQL        string
+		args            []interface{}
+		lockSQL         string
+		orderSQL        string
+		selectColumns   string
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+
+	orderSQL, err = entityDescription.orderBySQL(options.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.ForShare {
+		if transaction == nil {
+			return nil, ErrTransactionRequired
+		}
+
+		lockSQL, err = databaseContext.dialect().SharedLockClause()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, "")
+	if err != nil {
+		return nil, err
+	}
+
+	selectColumns, err = entityDescription.selectColumns("")
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement, err = entityDescription.readSQL(selectColumns, whereSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement += orderSQL
+	if limit > 0 {
+		selectStatement += " LIMIT ?"
+		args = append(args, limit)
+
+		if offset > 0 {
+			selectStatement += " OFFSET ?"
+			args = append(args, offset)
+		}
+	}
+	if lockSQL != "" {
+		selectStatement += " " + lockSQL
+	}
+
+	rows, err = databaseContext.query(ctx, transaction, entityDescription.Name, OpRead, selectStatement, args...)
+
+	if err != nil {
+		goto cleanup
+	}
+
+	entities, err = entityDescription.CreateFromRows(rows)
+
+	if err != nil {
+		goto cleanup
+	}
+
+	if options.SkipCache {
+		IdentityMapFromContext(ctx).refresh(entityDescription.Name, entities)
+	} else {
+		IdentityMapFromContext(ctx).deduplicate(entityDescription.Name, entities)
+	}
+
+	err = entityDescription.runAfterFind(entities)
+
+cleanup:
+	if rows != nil {
+		rows.Close()
+	}
+
+	return entities, err
+}
+
+func (entityDescription *EntityDescription) FindIDs(transaction *sql.Tx, clause *WhereClause) (ids []interface{}, err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereClause     *WhereClause
+		whereSQL        string
+		args            []interface{}
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+	whereClause = clause.clone()
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, "")
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement = fmt.Sprintf("SELECT %s FROM %s%s", databaseContext.quoteIdentifier(entityDescription.PrimaryKey), entityDescription.readSource(), whereSQL)
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, selectStatement, args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id interface{}
+
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Rows are handed to callback as the driver delivers them, so a slow grouped
+// scan can report progress before it finishes. An error from callback stops
+// the scan and is returned. groupColumn must be one the description knows.
+func (entityDescription *EntityDescription) StreamGroupCounts(transaction *sql.Tx, groupColumn string, clause *WhereClause, callback func(key interface{}, count int64) error) (err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereClause     *WhereClause
+		whereSQL        string
+		args            []interface{}
+		quotedColumn    string
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+	whereClause = clause.clone()
+
+	err = entityDescription.checkKnownColumn(groupColumn)
+	if err != nil {
+		return err
+	}
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, "")
+	if err != nil {
+		return err
+	}
+
+	quotedColumn = databaseContext.quoteIdentifier(groupColumn)
+	selectStatement = fmt.Sprintf("SELECT %s, COUNT(*) FROM %s%s GROUP BY %s", quotedColumn, entityDescription.readSource(), whereSQL, quotedColumn)
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, selectStatement, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key   interface{}
+			count int64
+		)
+
+		err = rows.Scan(&key, &count)
+		if err != nil {
+			return err
+		}
+
+		err = callback(key, count)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// The entities and their metadata are read with two queries ordered by primary
+// key inside one transaction, so the rows pair up positionally. A KeyedEntity
+// whose key differs from its metadata row's is ErrMetadataMismatch.
+func (entityDescription *EntityDescription) FindWithMeta(transaction *sql.Tx, keyName *string, value interface{}) (results []EntityWithMeta, err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		tableName       string
+		columnName      string
+		primaryKey      string
+		metaColumns     []string
+		selectColumns   string
+		whereClause     *WhereClause
+		whereSQL        string
+		args            []interface{}
+		selectStatement string
+		metaStatement   string
+		rows            *sql.Rows
+		entities        []Entity
+		index           int
+	)
+
+	databaseContext = entityDescription.Context
+	tableName = entityDescription.TableName
+
+	if keyName == nil {
+		columnName = entityDescription.PrimaryKey
+	} else {
+		columnName = *keyName
+	}
+
+	metaColumns = []string{entityDescription.PrimaryKey}
+	if entityDescription.VersionColumn != "" {
+		metaColumns = append(metaColumns, entityDescription.VersionColumn)
+	}
+	if entityDescription.UpdatedDateColumn != "" {
+		metaColumns = append(metaColumns, entityDescription.UpdatedDateColumn)
+	}
+
+	whereClause = Where(columnName, "=", value)
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args, err = whereClause.build(databaseContext, "")
+	if err != nil {
+		return nil, err
+	}
+
+	tableName = entityDescription.readSource()
+	primaryKey = databaseContext.quoteIdentifier(entityDescription.PrimaryKey)
+
+	selectColumns, err = entityDescription.selectColumns("")
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement, err = entityDescription.readSQL(selectColumns, " WHERE "+whereSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement += " ORDER BY " + primaryKey
+	metaStatement = fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", strings.Join(databaseContext.quoteIdentifiers(metaColumns), ", "), tableName, whereSQL, primaryKey)
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpRead)
+		if err != nil {
+			return nil, err
+		}
+
+		commitAtEnd = true
+	}
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, selectStatement, args...)
+	if err != nil {
+		goto cleanup
+	}
+
+	entities, err = entityDescription.CreateFromRows(rows)
+	rows.Close()
+	rows = nil
+	if err != nil {
+		goto cleanup
+	}
+
+	err = entityDescription.runAfterFind(entities)
+	if err != nil {
+		goto cleanup
+	}
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, metaStatement, args...)
+	if err != nil {
+		goto cleanup
+	}
+
+	for rows.Next() {
+		var (
+			primaryKey   interface{}
+			version      interface{}
+			modifiedDate interface{}
+			destinations []interface{}
+		)
+
+		if index >= len(entities) {
+			err = ErrMetadataMismatch
+			goto cleanup
+		}
+
+		destinations = []interface{}{&primaryKey}
+		if entityDescription.VersionColumn != "" {
+			destinations = append(destinations, &version)
+		}
+		if entityDescription.UpdatedDateColumn != "" {
+			destinations = append(destinations, &modifiedDate)
+		}
+
+		err = rows.Scan(destinations...)
+		if err != nil {
+			goto cleanup
+		}
+
+		if keyedEntity, ok := entities[index].(KeyedEntity); ok && keyString(keyedEntity.PrimaryKeyValue()) != keyString(primaryKey) {
+			err = fmt.Errorf("%w: %v against %v", ErrMetadataMismatch, keyedEntity.PrimaryKeyValue(), primaryKey)
+			goto cleanup
+		}
+
+		result := EntityWithMeta{Entity: entities[index], Version: version}
+		if modifiedDate != nil {
+			var coerced interface{}
+
+			coerced, err = coerceValue(modifiedDate, "INTEGER", timeType)
+			if err != nil {
+				goto cleanup
+			}
+
+			result.ModifiedAt = coerced.(time.Time)
+		}
+
+		results = append(results, result)
+		index++
+	}
+
+	err = rows.Err()
+	if err == nil && index != len(entities) {
+		err = ErrMetadataMismatch
+	}
+
+cleanup:
+	if rows != nil {
+		rows.Close()
+	}
+
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (entityDescription *EntityDescription) FindRelatedEntity(transaction *sql.Tx, targetEntityName string, queryKey string, queryValue interface{}) (entities []Entity, err error) {
+	return entityDescription.FindRelatedEntityContext(context.Background(), transaction, targetEntityName, queryKey, queryValue)
+}
+
+func (entityDescription *EntityDescription) FindRelatedEntityContext(ctx context.Context, transaction *sql.Tx, targetEntityName string, queryKey string, queryValue interface{}) (entities []Entity, err error) {
+	var (
+		databaseContext         *DatabaseContext
+		relationship            EntityRelationship
+		ok                      bool
+		targetEntityDescription EntityDescription
+		joinTableName           string
+		targetTableName         string
+		whereClause             *WhereClause
+		fromSQL                 string
+	)
+
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	databaseContext = entityDescription.Context
+
+	targetEntityDescription, ok = databaseContext.lookupEntityDescription(targetEntityName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEntity, targetEntityName)
+	}
+
+	relationship, ok = entityDescription.Relationships[targetEntityName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no relationship to %s", ErrUnknownRelationship, entityDescription.Name, targetEntityName)
+	}
+
+	joinTableName = relationship.JoinTableName
+	targetTableName = targetEntityDescription.TableName
+
+	if relationship.kind() == HasMany {
+		// SELECT * FROM comments WHERE comments.placemarkID=1
+		whereClause = Where(targetTableName+"."+relationship.ForeignKey, "=", queryValue)
+		fromSQL = targetEntityDescription.readSource()
+	} else {
+		// SELECT * FROM lists_placemarks LEFT OUTER JOIN placemarks ON lists_placemarks.placemarksID=placemarks.id WHERE lists_placemarks.listsID=1
+		whereClause = Where(joinTableName+"."+queryKey, "=", queryValue)
+		fromSQL = fmt.Sprintf("%s LEFT OUTER JOIN %s ON %s=%s", databaseContext.quoteIdentifier(joinTableName), targetEntityDescription.readSource(), databaseContext.quoteIdentifier(joinTableName+"."+relationship.ForeignKey), databaseContext.quoteIdentifier(targetTableName+"."+relationship.TargetKey))
+	}
+
+	return targetEntityDescription.findJoined(ctx, contextTransaction(ctx, transaction), whereClause, fromSQL)
+}
+
+// Walks a relationship registered on targetEntityName's description back from
+// this entity's side, through the same join table: queryKey is normally the
+// relationship's ForeignKey, and the join's SourceKey is matched against the
+// target's PrimaryKey. Only ManyToMany relationships can be walked back.
+func (entityDescription *EntityDescription) FindRelatedEntityReverse(transaction *sql.Tx, targetEntityName string, queryKey string, queryValue interface{}) (entities []Entity, err error) {
+	var (
+		databaseContext         *DatabaseContext
+		relationship            EntityRelationship
+		ok                      bool
+		targetEntityDescription EntityDescription
+		joinTableName           string
+		targetTableName         string
+		whereClause             *WhereClause
+		fromSQL                 string
+	)
+
+	databaseContext = entityDescription.Context
+
+	targetEntityDescription, ok = databaseContext.lookupEntityDescription(targetEntityName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEntity, targetEntityName)
+	}
+
+	relationship, ok = targetEntityDescription.Relationships[entityDescription.Name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no relationship to %s", ErrUnknownRelationship, targetEntityName, entityDescription.Name)
+	}
+
+	if relationship.kind() != ManyToMany {
+		return nil, fmt.Errorf("%w: %s to %s", ErrNotJoinRelationship, targetEntityName, entityDescription.Name)
+	}
+
+	joinTableName = relationship.JoinTableName
+	targetTableName = targetEntityDescription.TableName
+
+	// SELECT * FROM lists_placemarks LEFT OUTER JOIN lists ON lists_placemarks.listsID=lists.id WHERE lists_placemarks.placemarksID=1
+	whereClause = Where(joinTableName+"."+queryKey, "=", queryValue)
+	fromSQL = fmt.Sprintf("%s LEFT OUTER JOIN %s ON %s=%s", databaseContext.quoteIdentifier(joinTableName), targetEntityDescription.readSource(), databaseContext.quoteIdentifier(joinTableName+"."+relationship.SourceKey), databaseContext.quoteIdentifier(targetTableName+"."+targetEntityDescription.PrimaryKey))
+
+	return targetEntityDescription.findJoined(context.Background(), transaction, whereClause, fromSQL)
+}
+
+// Selects this entity's columns from fromSQL, which reads the entity's table
+// alongside any others, with hook-added conditions qualified by TableName.
+func (entityDescription *EntityDescription) findJoined(ctx context.Context, transaction *sql.Tx, whereClause *WhereClause, fromSQL string) (entities []Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereSQL        string
+		args            []interface{}
+		selectColumns   string
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, entityDescription.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	selectColumns, err = entityDescription.selectColumns(entityDescription.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement = fmt.Sprintf("SELECT %s FROM %s%s", selectColumns, fromSQL, whereSQL)
+
+	rows, err = databaseContext.query(ctx, transaction, entityDescription.Name, OpRead, selectStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entities, err = entityDescription.CreateFromRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	IdentityMapFromContext(ctx).deduplicate(entityDescription.Name, entities)
+
+	err = entityDescription.runAfterFind(entities)
+	if err != nil {
+		return nil, err
+	}
+
+	return entities, nil
+}
+
+// Runs querySQL as given and scans each row it returns with ScanFromRow, so
+// its columns must be the ones the entity expects. BeforeFind is not consulted
+// and soft-deleted rows are not filtered, since there is no clause to add to;
+// AfterFind still runs.
+func (entityDescription *EntityDescription) Raw(transaction *sql.Tx, querySQL string, args ...interface{}) (entities []Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, querySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entities, err = entityDescription.CreateFromRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = entityDescription.runAfterFind(entities)
+	if err != nil {
+		return nil, err
+	}
+
+	return entities, nil
+}
+
+// Database Maintenance
+
+func (databaseContext *DatabaseContext) TruncateAll(confirm bool) (err error) {
+	var (
+		transaction *sql.Tx
+		tableNames  []string
+		tableName   string
+	)
+
+	if !confirm {
+		return ErrTruncateNotConfirmed
+	}
+
+	tableNames = databaseContext.truncationOrder()
+
+	transaction, err = databaseContext.begin(context.Background(), "", OpWrite)
+	if err != nil {
+		return err
+	}
+
+	for _, tableName = range tableNames {
+		_, err = databaseContext.exec(context.Background(), transaction, "", fmt.Sprintf("DELETE FROM %s", databaseContext.quoteIdentifier(tableName)))
+		if err != nil {
+			goto cleanup
+		}
+	}
+
+cleanup:
+	if err != nil {
+		transaction.Rollback()
+	} else {
+		err = transaction.Commit()
+	}
+
+	return err
+}
+
+// Join tables reference the entity tables on both sides of a relationship, so
+// they are emptied before any entity table. A HasMany target's rows point at
+// their source, so its table is emptied before the source's. Tables are
+// otherwise taken in name order, and any left in a cycle go last in name
+// order.
+func (databaseContext *DatabaseContext) truncationOrder() (tableNames []string) {
+	var (
+		joinTableNames   []string
+		entityTableNames []string
+		seenTableNames   map[string]bool
+		referencingNames map[string][]string
+		emittedNames     map[string]bool
+	)
+
+	seenTableNames = make(map[string]bool)
+	referencingNames = make(map[string][]string)
+
+	for _, entityDescription := range databaseContext.registeredEntityDescriptions() {
+		for _, relationship := range entityDescription.Relationships {
+			if relationship.kind() == HasMany {
+				targetEntityDescription, ok := databaseContext.lookupEntityDescription(relationship.EntityName)
+				if ok && targetEntityDescription.TableName != entityDescription.TableName {
+					referencingNames[entityDescription.TableName] = append(referencingNames[entityDescription.TableName], targetEntityDescription.TableName)
+				}
+			}
+
+			if relationship.JoinTableName == "" || seenTableNames[relationship.JoinTableName] {
+				continue
+			}
+
+			seenTableNames[relationship.JoinTableName] = true
+			joinTableNames = append(joinTableNames, relationship.JoinTableName)
+		}
+	}
+
+	for _, entityDescription := range databaseContext.registeredEntityDescriptions() {
+		if entityDescription.TableName == "" || entityDescription.checkWritable() != nil || seenTableNames[entityDescription.TableName] {
+			continue
+		}
+
+		seenTableNames[entityDescription.TableName] = true
+		entityTableNames = append(entityTableNames, entityDescription.TableName)
+	}
+
+	sort.Strings(joinTableNames)
+	sort.Strings(entityTableNames)
+
+	tableNames = joinTableNames
+	emittedNames = make(map[string]bool)
+
+	for len(tableNames) < len(joinTableNames)+len(entityTableNames) {
+		readyName := ""
+
+		for _, entityTableName := range entityTableNames {
+			if emittedNames[entityTableName] {
+				continue
+			}
+
+			ready := true
+			for _, referencingName := range referencingNames[entityTableName] {
+				if seenTableNames[referencingName] && !emittedNames[referencingName] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				readyName = entityTableName
+				break
+			}
+		}
+
+		// Only a cycle is left; take the rest in name order.
+		if readyName == "" {
+			for _, entityTableName := range entityTableNames {
+				if !emittedNames[entityTableName] {
+					tableNames = append(tableNames, entityTableName)
+				}
+			}
+
+			break
+		}
+
+		emittedNames[readyName] = true
+		tableNames = append(tableNames, readyName)
+	}
+
+	return tableNames
 }
@@ -0,0 +1,1211 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Fake Driver
+
+// The tree carries no SQL driver, so tests run against a driver that hands
+// every statement to a responder standing in for the database.
+
+type fakeResponse struct {
+	columns      []string
+	rows         [][]driver.Value
+	rowsErrAt    int
+	rowsErr      error
+	rowsAffected int64
+	lastInsertID int64
+}
+
+type fakeResponder func(query string, args []driver.Value) (fakeResponse, error)
+
+type fakeDatabase struct {
+	mutex      sync.Mutex
+	respond    fakeResponder
+	statements []string
+	prepares   int
+}
+
+func newFakeDatabase(respond fakeResponder) (database *sql.DB, fake *fakeDatabase) {
+	fake = &fakeDatabase{respond: respond}
+	return sql.OpenDB(fakeConnector{fake: fake}), fake
+}
+
+func (fake *fakeDatabase) run(query string, args []driver.Value) (fakeResponse, error) {
+	fake.mutex.Lock()
+	fake.statements = append(fake.statements, query)
+	fake.mutex.Unlock()
+
+	if fake.respond == nil {
+		return fakeResponse{}, nil
+	}
+
+	return fake.respond(query, args)
+}
+
+func (fake *fakeDatabase) ran(prefix string) (count int) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	for _, statement := range fake.statements {
+		if strings.HasPrefix(statement, prefix) {
+			count++
+		}
+	}
+
+	return count
+}
+
+type fakeConnector struct {
+	fake *fakeDatabase
+}
+
+func (connector fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &fakeConn{fake: connector.fake}, nil
+}
+
+func (connector fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("fake: open through fakeConnector")
+}
+
+type fakeConn struct {
+	fake *fakeDatabase
+}
+
+func (conn *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	conn.fake.mutex.Lock()
+	conn.fake.prepares++
+	conn.fake.mutex.Unlock()
+
+	return &fakeStmt{fake: conn.fake, query: query}, nil
+}
+
+func (conn *fakeConn) Close() error {
+	return nil
+}
+
+func (conn *fakeConn) Begin() (driver.Tx, error) {
+	conn.fake.run("BEGIN", nil)
+	return &fakeTx{fake: conn.fake}, nil
+}
+
+type fakeTx struct {
+	fake *fakeDatabase
+}
+
+func (tx *fakeTx) Commit() error {
+	_, err := tx.fake.run("COMMIT", nil)
+	return err
+}
+
+func (tx *fakeTx) Rollback() error {
+	_, err := tx.fake.run("ROLLBACK", nil)
+	return err
+}
+
+type fakeStmt struct {
+	fake  *fakeDatabase
+	query string
+}
+
+func (stmt *fakeStmt) Close() error {
+	return nil
+}
+
+func (stmt *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (stmt *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	response, err := stmt.fake.run(stmt.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return fakeResult{response: response}, nil
+}
+
+func (stmt *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	response, err := stmt.fake.run(stmt.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeRows{response: response}, nil
+}
+
+type fakeResult struct {
+	response fakeResponse
+}
+
+func (result fakeResult) LastInsertId() (int64, error) {
+	return result.response.lastInsertID, nil
+}
+
+func (result fakeResult) RowsAffected() (int64, error) {
+	return result.response.rowsAffected, nil
+}
+
+// A rowsErr is returned in place of the row at rowsErrAt, as a driver does
+// when a row fails to arrive partway through a result.
+type fakeRows struct {
+	response fakeResponse
+	index    int
+}
+
+func (rows *fakeRows) Columns() []string {
+	return rows.response.columns
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if rows.response.rowsErr != nil && rows.index == rows.response.rowsErrAt {
+		return rows.response.rowsErr
+	}
+
+	if rows.index >= len(rows.response.rows) {
+		return io.EOF
+	}
+
+	copy(dest, rows.response.rows[rows.index])
+	rows.index++
+
+	return nil
+}
+
+// Test Entities
+
+type testPlace struct {
+	ID   int64
+	Name string
+}
+
+func (place *testPlace) ScanFromRow(rows *sql.Rows) (bool, error) {
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+
+	err := rows.Scan(&place.ID, &place.Name)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (place *testPlace) PrimaryKeyValue() interface{} {
+	return place.ID
+}
+
+var placeColumns = []string{"id", "name"}
+
+type testUpdatedPlace struct {
+	ID      int64
+	Name    string
+	Updated sql.NullInt64
+}
+
+func (place *testUpdatedPlace) ScanFromRow(rows *sql.Rows) (bool, error) {
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+
+	err := rows.Scan(&place.ID, &place.Name, &place.Updated)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func newPlaceContext(respond fakeResponder) (databaseContext *DatabaseContext, fake *fakeDatabase) {
+	var (
+		database *sql.DB
+	)
+
+	database, fake = newFakeDatabase(respond)
+	databaseContext = NewDatabaseContext(database, Options{})
+
+	databaseContext.RegisterEntityDescription(EntityDescription{
+		Name:               "places",
+		TableName:          "places",
+		PrimaryKey:         "id",
+		Columns:            []ColumnDef{{Name: "name", Type: "TEXT"}},
+		CreateZeroInstance: func() Entity { return &testPlace{} },
+	})
+
+	return databaseContext, fake
+}
+
+// Entity Descriptions
+
+// Meant for go test -race, which reports any unguarded access to the map.
+func TestRegistryIsSafeForConcurrentUse(t *testing.T) {
+	var (
+		waitGroup sync.WaitGroup
+	)
+
+	databaseContext, _ := newPlaceContext(nil)
+
+	for worker := 0; worker < 8; worker++ {
+		waitGroup.Add(1)
+
+		go func(worker int) {
+			defer waitGroup.Done()
+
+			for iteration := 0; iteration < 200; iteration++ {
+				name := fmt.Sprintf("entity%d_%d", worker, iteration%10)
+
+				databaseContext.RegisterEntityDescription(EntityDescription{Name: name, TableName: name, PrimaryKey: "id"})
+
+				if databaseContext.EntityDescriptionForName(name).Name != name {
+					t.Errorf("lookup of %s after registering it failed", name)
+					return
+				}
+
+				if databaseContext.EntityDescriptionForName("places").Name != "places" {
+					t.Errorf("lookup of places failed")
+					return
+				}
+
+				databaseContext.registeredEntityDescriptions()
+			}
+		}(worker)
+	}
+
+	waitGroup.Wait()
+}
+
+// Entity Find
+
+func TestFindEntitiesReturnsQueryError(t *testing.T) {
+	queryErr := errors.New("no such column: nope")
+
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		if strings.HasPrefix(query, "SELECT") {
+			return fakeResponse{}, queryErr
+		}
+
+		return fakeResponse{}, nil
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+	keyName := "nope"
+
+	entities, err := places.FindEntities(nil, &keyName, 1)
+	if !errors.Is(err, queryErr) {
+		t.Fatalf("FindEntities error = %v, want %v", err, queryErr)
+	}
+
+	if entities != nil {
+		t.Fatalf("FindEntities entities = %v, want nil", entities)
+	}
+}
+
+func TestFindEntitySelectsKeyWithWriteOnlyColumns(t *testing.T) {
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		if !strings.HasPrefix(query, "SELECT id, name FROM places WHERE") {
+			return fakeResponse{}, fmt.Errorf("unexpected query %q", query)
+		}
+
+		return fakeResponse{columns: placeColumns, rows: [][]driver.Value{{args[0], "Prospect Park"}}}, nil
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.Columns = append(places.Columns, ColumnDef{Name: "secret", Type: "TEXT"})
+	places.WriteOnlyColumns = []string{"secret"}
+	databaseContext.RegisterEntityDescription(places)
+
+	entity, err := places.FindEntity(nil, nil, int64(7))
+	if err != nil {
+		t.Fatalf("FindEntity: %v", err)
+	}
+
+	if place := entity.(*testPlace); place.ID != 7 || place.Name != "Prospect Park" {
+		t.Fatalf("FindEntity = %+v", place)
+	}
+}
+
+func TestFindEntitiesReportsMalformedRow(t *testing.T) {
+	rowErr := errors.New("connection reset mid-result")
+
+	for _, test := range []struct {
+		name     string
+		response fakeResponse
+	}{
+		{
+			name: "unscannable value",
+			response: fakeResponse{columns: placeColumns, rows: [][]driver.Value{
+				{int64(1), "Prospect Park"},
+				{"not a number", "Fort Greene Park"},
+				{int64(3), "McCarren Park"},
+			}},
+		},
+		{
+			name: "driver error",
+			response: fakeResponse{columns: placeColumns, rows: [][]driver.Value{
+				{int64(1), "Prospect Park"},
+				{int64(2), "Fort Greene Park"},
+				{int64(3), "McCarren Park"},
+			}, rowsErrAt: 2, rowsErr: rowErr},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+				return test.response, nil
+			})
+
+			places := databaseContext.EntityDescriptionForName("places")
+			keyName := "name"
+
+			entities, err := places.FindEntities(nil, &keyName, "park")
+			if err == nil {
+				t.Fatalf("FindEntities returned %d entities and no error", len(entities))
+			}
+
+			if test.response.rowsErr != nil && !errors.Is(err, rowErr) {
+				t.Fatalf("FindEntities error = %v, want %v", err, rowErr)
+			}
+
+			if entities != nil {
+				t.Fatalf("FindEntities entities = %v, want nil", entities)
+			}
+		})
+	}
+}
+
+func TestQueryKnowsStampedColumns(t *testing.T) {
+	var (
+		selectSQL string
+	)
+
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		selectSQL = query
+		return fakeResponse{columns: placeColumns}, nil
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.CreatedDateColumn = "created"
+	places.UpdatedDateColumn = "updated"
+
+	_, err := places.Query().Where("updated", ">", int64(0)).OrderBy("created", true).All(nil)
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+
+	if !strings.HasSuffix(selectSQL, "WHERE updated>? ORDER BY created DESC") {
+		t.Fatalf("select = %q", selectSQL)
+	}
+
+	_, err = places.Query().Where("nope", "=", int64(0)).All(nil)
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("All on an unknown column error = %v, want %v", err, ErrUnknownColumn)
+	}
+}
+
+// A single places table, enough for finds by id and the statements that
+// write it, with deleted standing in for the soft-delete column.
+type fakePlaceTable struct {
+	mutex       sync.Mutex
+	rows        []fakePlaceRow
+	withUpdated bool
+}
+
+type fakePlaceRow struct {
+	id      int64
+	name    string
+	deleted interface{}
+	updated interface{}
+}
+
+func (table *fakePlaceTable) respond(query string, args []driver.Value) (fakeResponse, error) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "SELECT"):
+		response := fakeResponse{columns: placeColumns}
+		if table.withUpdated {
+			response.columns = []string{"id", "name", "updated"}
+		}
+
+		for _, row := range table.rows {
+			if row.id != args[0].(int64) || (strings.Contains(query, "deleted IS NULL") && row.deleted != nil) {
+				continue
+			}
+
+			values := []driver.Value{row.id, row.name}
+			if table.withUpdated {
+				values = append(values, row.updated)
+			}
+
+			response.rows = append(response.rows, values)
+		}
+
+		return response, nil
+
+	case strings.HasPrefix(query, "UPDATE places SET deleted=?"):
+		for index, row := range table.rows {
+			if row.id == args[1].(int64) && row.deleted == nil {
+				table.rows[index].deleted = args[0]
+				return fakeResponse{rowsAffected: 1}, nil
+			}
+		}
+
+	case strings.HasPrefix(query, "UPDATE places SET name=? WHERE"):
+		for index, row := range table.rows {
+			if row.id == args[1].(int64) {
+				table.rows[index].name = args[0].(string)
+				return fakeResponse{rowsAffected: 1}, nil
+			}
+		}
+
+	case strings.HasPrefix(query, "UPDATE places SET name=?, updated=?"):
+		for index, row := range table.rows {
+			if row.id == args[2].(int64) {
+				table.rows[index].name = args[0].(string)
+				table.rows[index].updated = args[1]
+				return fakeResponse{rowsAffected: 1}, nil
+			}
+		}
+	}
+
+	return fakeResponse{}, nil
+}
+
+func (table *fakePlaceTable) row(id int64) (fakePlaceRow, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	for _, row := range table.rows {
+		if row.id == id {
+			return row, true
+		}
+	}
+
+	return fakePlaceRow{}, false
+}
+
+// Entity Deletion
+
+func TestSoftDeleteHidesRowFromFinds(t *testing.T) {
+	table := &fakePlaceTable{rows: []fakePlaceRow{{id: 1, name: "Prospect Park"}, {id: 2, name: "Fort Greene Park"}}}
+
+	databaseContext, fake := newPlaceContext(table.respond)
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.SoftDeleteColumn = "deleted"
+	databaseContext.RegisterEntityDescription(places)
+
+	err := places.Delete(nil, int64(1))
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if fake.ran("DELETE") != 0 {
+		t.Fatalf("soft delete issued a DELETE: %v", fake.statements)
+	}
+
+	_, err = places.FindEntity(nil, nil, int64(1))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("FindEntity of a soft-deleted row error = %v, want %v", err, ErrNotFound)
+	}
+
+	row, ok := table.row(1)
+	if !ok || row.deleted == nil {
+		t.Fatalf("soft-deleted row = %+v, %v; want it kept with deleted stamped", row, ok)
+	}
+
+	entity, err := places.FindEntity(nil, nil, int64(2))
+	if err != nil || entity.(*testPlace).Name != "Fort Greene Park" {
+		t.Fatalf("FindEntity of a live row = %v, %v", entity, err)
+	}
+
+	err = places.Delete(nil, int64(1))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second Delete error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+// Entity Relationships
+
+func registerLists(databaseContext *DatabaseContext, joinTableName string) {
+	lists := EntityDescription{
+		Name:               "lists",
+		TableName:          "lists",
+		PrimaryKey:         "id",
+		Columns:            []ColumnDef{{Name: "name", Type: "TEXT"}},
+		CreateZeroInstance: func() Entity { return &testPlace{} },
+	}
+
+	lists.RegisterRelationship(EntityRelationship{
+		EntityName:    "places",
+		JoinTableName: joinTableName,
+		SourceKey:     "listsID",
+		ForeignKey:    "placesID",
+		TargetKey:     "id",
+	})
+
+	databaseContext.RegisterEntityDescription(lists)
+}
+
+func TestFindRelatedEntityReportsMissingJoinTable(t *testing.T) {
+	tableErr := errors.New("no such table: lists_missing")
+
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		if strings.Contains(query, "lists_missing") {
+			return fakeResponse{}, tableErr
+		}
+
+		return fakeResponse{}, nil
+	})
+
+	registerLists(databaseContext, "lists_missing")
+	lists := databaseContext.EntityDescriptionForName("lists")
+
+	entities, err := lists.FindRelatedEntity(nil, "places", "listsID", int64(1))
+	if !errors.Is(err, tableErr) {
+		t.Fatalf("FindRelatedEntity error = %v, want %v", err, tableErr)
+	}
+
+	if entities != nil {
+		t.Fatalf("FindRelatedEntity entities = %v, want nil", entities)
+	}
+}
+
+func TestFindRelatedEntityReportsUnknownNames(t *testing.T) {
+	databaseContext, fake := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		return fakeResponse{columns: placeColumns}, nil
+	})
+
+	registerLists(databaseContext, "lists_places")
+
+	for _, test := range []struct {
+		name       string
+		entityName string
+		targetName string
+		want       error
+	}{
+		{name: "unknown entity", entityName: "lists", targetName: "parks", want: ErrUnknownEntity},
+		{name: "unknown relationship", entityName: "places", targetName: "lists", want: ErrUnknownRelationship},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			entityDescription := databaseContext.EntityDescriptionForName(test.entityName)
+
+			entities, err := entityDescription.FindRelatedEntity(nil, test.targetName, "listsID", int64(1))
+			if !errors.Is(err, test.want) {
+				t.Fatalf("FindRelatedEntity error = %v, want %v", err, test.want)
+			}
+
+			if entities != nil {
+				t.Fatalf("FindRelatedEntity entities = %v, want nil", entities)
+			}
+		})
+	}
+
+	if len(fake.statements) != 0 {
+		t.Fatalf("want nothing run for unknown names, ran %v", fake.statements)
+	}
+}
+
+func TestRelationshipTraversalBothWays(t *testing.T) {
+	var (
+		joinRows   = [][2]int64{{1, 10}, {1, 11}, {2, 10}}
+		placeNames = map[int64]string{10: "Prospect Park", 11: "Fort Greene Park"}
+		listNames  = map[int64]string{1: "Parks", 2: "Favorites"}
+	)
+
+	// The forward query joins places and matches listsID; the reverse joins
+	// lists and matches placesID.
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		response := fakeResponse{columns: placeColumns}
+
+		for _, joinRow := range joinRows {
+			switch {
+			case strings.Contains(query, "JOIN places ON lists_places.placesID=places.id WHERE lists_places.listsID=?"):
+				if joinRow[0] == args[0].(int64) {
+					response.rows = append(response.rows, []driver.Value{joinRow[1], placeNames[joinRow[1]]})
+				}
+
+			case strings.Contains(query, "JOIN lists ON lists_places.listsID=lists.id WHERE lists_places.placesID=?"):
+				if joinRow[1] == args[0].(int64) {
+					response.rows = append(response.rows, []driver.Value{joinRow[0], listNames[joinRow[0]]})
+				}
+
+			default:
+				return fakeResponse{}, fmt.Errorf("unexpected query %q", query)
+			}
+		}
+
+		return response, nil
+	})
+
+	registerLists(databaseContext, "lists_places")
+	lists := databaseContext.EntityDescriptionForName("lists")
+	places := databaseContext.EntityDescriptionForName("places")
+
+	forward, err := lists.FindRelatedEntity(nil, "places", "listsID", int64(1))
+	if err != nil {
+		t.Fatalf("FindRelatedEntity: %v", err)
+	}
+
+	if got := testPlaceNames(forward); got != "Prospect Park, Fort Greene Park" {
+		t.Fatalf("places on list 1 = %s", got)
+	}
+
+	reverse, err := places.FindRelatedEntityReverse(nil, "lists", "placesID", int64(10))
+	if err != nil {
+		t.Fatalf("FindRelatedEntityReverse: %v", err)
+	}
+
+	if got := testPlaceNames(reverse); got != "Parks, Favorites" {
+		t.Fatalf("lists holding place 10 = %s", got)
+	}
+}
+
+func testPlaceNames(entities []Entity) string {
+	var (
+		names []string
+	)
+
+	for _, entity := range entities {
+		names = append(names, entity.(*testPlace).Name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// Entity Update
+
+func TestUpdateStampsLaterUpdatedDate(t *testing.T) {
+	table := &fakePlaceTable{rows: []fakePlaceRow{{id: 1, name: "Prospect Park"}}, withUpdated: true}
+
+	databaseContext, fake := newPlaceContext(table.respond)
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.UpdatedDateColumn = "updated"
+	places.CreateZeroInstance = func() Entity { return &testUpdatedPlace{} }
+	databaseContext.RegisterEntityDescription(places)
+
+	first, err := places.Update(nil, int64(1), map[string]interface{}{"name": "Prospect Park West"})
+	if err != nil {
+		t.Fatalf("first Update: %v", err)
+	}
+
+	// Dates are whole Unix seconds, so the second update waits for the next.
+	for second := time.Now().Unix(); time.Now().Unix() == second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	second, err := places.Update(nil, int64(1), map[string]interface{}{"name": "Prospect Park East"})
+	if err != nil {
+		t.Fatalf("second Update: %v", err)
+	}
+
+	firstUpdated := first.(*testUpdatedPlace).Updated
+	secondUpdated := second.(*testUpdatedPlace).Updated
+	if !firstUpdated.Valid || !secondUpdated.Valid || secondUpdated.Int64 <= firstUpdated.Int64 {
+		t.Fatalf("updated dates = %v then %v, want strictly increasing", firstUpdated, secondUpdated)
+	}
+
+	if second.(*testUpdatedPlace).Name != "Prospect Park East" {
+		t.Fatalf("re-selected name = %q", second.(*testUpdatedPlace).Name)
+	}
+
+	if fake.ran("UPDATE") != 2 {
+		t.Fatalf("want one UPDATE per Update, ran %v", fake.statements)
+	}
+}
+
+func TestUpdateWithResultReturnsStamp(t *testing.T) {
+	table := &fakePlaceTable{rows: []fakePlaceRow{{id: 1, name: "Prospect Park"}}, withUpdated: true}
+
+	databaseContext, fake := newPlaceContext(table.respond)
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.UpdatedDateColumn = "updated"
+
+	updateResult, err := places.UpdateWithResult(nil, int64(1), map[string]interface{}{"name": "Prospect Park West"})
+	if err != nil {
+		t.Fatalf("UpdateWithResult: %v", err)
+	}
+
+	row, _ := table.row(1)
+	if updateResult.Entity != nil || updateResult.UpdatedAt.Unix() != row.updated {
+		t.Fatalf("result = %+v, want no entity and the stamped %v", updateResult, row.updated)
+	}
+
+	if fake.ran("SELECT") != 0 {
+		t.Fatalf("want no re-select, ran %v", fake.statements)
+	}
+}
+
+func TestUpdateRefreshesIdentityMap(t *testing.T) {
+	table := &fakePlaceTable{rows: []fakePlaceRow{{id: 1, name: "Prospect Park"}}}
+
+	databaseContext, _ := newPlaceContext(table.respond)
+	places := databaseContext.EntityDescriptionForName("places")
+
+	ctx, _ := WithIdentityMap(context.Background())
+
+	_, err := places.FindEntityContext(ctx, nil, nil, int64(1))
+	if err != nil {
+		t.Fatalf("FindEntityContext: %v", err)
+	}
+
+	_, err = places.UpdateContext(ctx, nil, int64(1), map[string]interface{}{"name": "Prospect Park West"})
+	if err != nil {
+		t.Fatalf("UpdateContext: %v", err)
+	}
+
+	entity, err := places.FindEntityContext(ctx, nil, nil, int64(1))
+	if err != nil {
+		t.Fatalf("FindEntityContext after update: %v", err)
+	}
+
+	if name := entity.(*testPlace).Name; name != "Prospect Park West" {
+		t.Fatalf("name after update = %q, want the updated row", name)
+	}
+}
+
+func TestSkipCacheReplacesMappedEntity(t *testing.T) {
+	table := &fakePlaceTable{rows: []fakePlaceRow{{id: 1, name: "Prospect Park"}}}
+
+	databaseContext, _ := newPlaceContext(table.respond)
+	places := databaseContext.EntityDescriptionForName("places")
+
+	ctx, _ := WithIdentityMap(context.Background())
+
+	_, err := places.FindEntityContext(ctx, nil, nil, int64(1))
+	if err != nil {
+		t.Fatalf("FindEntityContext: %v", err)
+	}
+
+	table.rows[0].name = "Prospect Park West"
+
+	entity, err := places.FindEntityContext(ctx, nil, nil, int64(1))
+	if err != nil {
+		t.Fatalf("FindEntityContext: %v", err)
+	}
+
+	if name := entity.(*testPlace).Name; name != "Prospect Park" {
+		t.Fatalf("name without SkipCache = %q, want the mapped instance", name)
+	}
+
+	for _, options := range []FindOptions{{SkipCache: true}, {}} {
+		entity, err = places.FindEntityWithOptionsContext(ctx, nil, Where("id", "=", int64(1)), options)
+		if err != nil {
+			t.Fatalf("FindEntityWithOptionsContext: %v", err)
+		}
+
+		if name := entity.(*testPlace).Name; name != "Prospect Park West" {
+			t.Fatalf("name with %+v = %q, want the fresh row", options, name)
+		}
+	}
+}
+
+// Entity Creation
+
+func TestCreateKeysOnPrimaryKey(t *testing.T) {
+	databaseContext, fake := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		switch {
+		case query == "INSERT INTO places (name) VALUES ($1) RETURNING placeID":
+			return fakeResponse{columns: []string{"placeID"}, rows: [][]driver.Value{{int64(5)}}}, nil
+		case strings.HasPrefix(query, "SELECT * FROM places WHERE places.placeID=$1"):
+			return fakeResponse{columns: placeColumns, rows: [][]driver.Value{{args[0], "Prospect Park"}}}, nil
+		case strings.HasPrefix(query, "UPDATE places SET created=$1 WHERE placeID=$2"):
+			return fakeResponse{rowsAffected: 1}, nil
+		case query == "BEGIN" || query == "COMMIT":
+			return fakeResponse{}, nil
+		}
+
+		return fakeResponse{}, fmt.Errorf("unexpected query %q", query)
+	})
+
+	databaseContext.Dialect = PostgresDialect{}
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.PrimaryKey = "placeID"
+	places.CreatedDateColumn = "created"
+
+	err := places.BuildInsertStatement(databaseContext)
+	if err != nil {
+		t.Fatalf("BuildInsertStatement: %v", err)
+	}
+
+	entity, err := places.Create(nil, "Prospect Park")
+	if err != nil {
+		t.Fatalf("Create: %v (ran %v)", err, fake.statements)
+	}
+
+	if entity.(*testPlace).ID != 5 {
+		t.Fatalf("Create = %+v, want the row keyed 5", entity)
+	}
+}
+
+func TestCreateReturnsInsertError(t *testing.T) {
+	insertErr := errors.New("constraint failed")
+
+	databaseContext, fake := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		if strings.HasPrefix(query, "INSERT") {
+			return fakeResponse{}, insertErr
+		}
+
+		return fakeResponse{}, nil
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+
+	err := places.BuildInsertStatement(databaseContext)
+	if err != nil {
+		t.Fatalf("BuildInsertStatement: %v", err)
+	}
+
+	_, err = places.Create(nil, "Prospect Park")
+	if !errors.Is(err, insertErr) {
+		t.Fatalf("Create error = %v, want %v", err, insertErr)
+	}
+
+	if fake.ran("ROLLBACK") != 1 || fake.ran("COMMIT") != 0 {
+		t.Fatalf("Create did not roll back: %v", fake.statements)
+	}
+}
+
+// Hands out ids to inserted rows, one name value each, and reads back any
+// id it is asked for; a trailing LIMIT argument is not an id.
+type fakeInsertTable struct {
+	mutex  sync.Mutex
+	lastID int64
+}
+
+func (table *fakeInsertTable) respond(query string, args []driver.Value) (fakeResponse, error) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	switch {
+	case strings.HasPrefix(query, "INSERT"):
+		table.lastID += int64(len(args))
+		return fakeResponse{rowsAffected: int64(len(args)), lastInsertID: table.lastID}, nil
+
+	case strings.HasPrefix(query, "SELECT"):
+		if strings.HasSuffix(query, " LIMIT ?") {
+			args = args[:len(args)-1]
+		}
+
+		response := fakeResponse{columns: placeColumns}
+		for _, arg := range args {
+			response.rows = append(response.rows, []driver.Value{arg, "Prospect Park"})
+		}
+
+		return response, nil
+	}
+
+	return fakeResponse{rowsAffected: 1}, nil
+}
+
+const benchmarkRows = 100
+
+func newInsertBenchmark(b *testing.B) (places EntityDescription, rows [][]interface{}, fake *fakeDatabase) {
+	var (
+		databaseContext *DatabaseContext
+	)
+
+	databaseContext, fake = newPlaceContext((&fakeInsertTable{}).respond)
+
+	places = databaseContext.EntityDescriptionForName("places")
+	places.CreatedDateColumn = "created"
+
+	err := places.BuildInsertStatement(databaseContext)
+	if err != nil {
+		b.Fatalf("BuildInsertStatement: %v", err)
+	}
+
+	for index := 0; index < benchmarkRows; index++ {
+		rows = append(rows, []interface{}{fmt.Sprintf("place %d", index)})
+	}
+
+	return places, rows, fake
+}
+
+// Both benchmarks insert benchmarkRows rows per operation into a fake that
+// answers at once, so the time is the package's own; statements/op is what a
+// real database would add round trips for.
+func BenchmarkCreateLoop(b *testing.B) {
+	places, rows, fake := newInsertBenchmark(b)
+
+	b.ResetTimer()
+
+	for iteration := 0; iteration < b.N; iteration++ {
+		for _, row := range rows {
+			_, err := places.Create(nil, row...)
+			if err != nil {
+				b.Fatalf("Create: %v", err)
+			}
+		}
+	}
+
+	b.ReportMetric(float64(len(fake.statements))/float64(b.N), "statements/op")
+}
+
+func BenchmarkCreateMany(b *testing.B) {
+	places, rows, fake := newInsertBenchmark(b)
+
+	b.ResetTimer()
+
+	for iteration := 0; iteration < b.N; iteration++ {
+		entities, err := places.CreateMany(nil, rows)
+		if err != nil || len(entities) != len(rows) {
+			b.Fatalf("CreateMany = %d entities, %v", len(entities), err)
+		}
+	}
+
+	b.ReportMetric(float64(len(fake.statements))/float64(b.N), "statements/op")
+}
+
+func TestCreateManyReportsTheFailedRow(t *testing.T) {
+	var (
+		batchResult *BatchResult
+	)
+
+	table := &fakeInsertTable{}
+	insertErr := errors.New("UNIQUE constraint failed: places.name")
+
+	databaseContext, fake := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		if strings.HasPrefix(query, "INSERT") {
+			for _, arg := range args {
+				if arg == "place 3" {
+					return fakeResponse{}, insertErr
+				}
+			}
+		}
+
+		return table.respond(query, args)
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+
+	err := places.BuildInsertStatement(databaseContext)
+	if err != nil {
+		t.Fatalf("BuildInsertStatement: %v", err)
+	}
+
+	_, err = places.CreateMany(nil, [][]interface{}{{"place 0"}, {"place 1"}, {"place 2"}, {"place 3"}, {"place 4"}})
+	if !errors.As(err, &batchResult) || !errors.Is(err, insertErr) {
+		t.Fatalf("CreateMany error = %v, want a BatchResult", err)
+	}
+
+	if batchResult.FailedIndex != 3 || batchResult.SuccessCount != 3 {
+		t.Fatalf("FailedIndex, SuccessCount = %d, %d, want 3, 3", batchResult.FailedIndex, batchResult.SuccessCount)
+	}
+
+	if fake.ran("ROLLBACK TO SAVEPOINT") != 2 || fake.ran("COMMIT") != 0 {
+		t.Fatalf("statements = %q", fake.statements)
+	}
+}
+
+// Upserts
+
+func TestUpsertStampsOnlyTheLiveConflictRow(t *testing.T) {
+	var (
+		inserted  bool
+		stampSQL  string
+		stampArgs []driver.Value
+	)
+
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		switch {
+		case strings.HasPrefix(query, "INSERT"):
+			inserted = true
+		case strings.HasPrefix(query, "UPDATE"):
+			stampSQL, stampArgs = query, args
+		case strings.HasPrefix(query, "SELECT") && inserted:
+			return fakeResponse{columns: placeColumns, rows: [][]driver.Value{{int64(1), args[0]}}}, nil
+		case strings.HasPrefix(query, "SELECT"):
+			return fakeResponse{columns: placeColumns}, nil
+		}
+
+		return fakeResponse{rowsAffected: 1}, nil
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.CreatedDateColumn = "created"
+	places.ConflictWhere = "deleted IS NULL"
+
+	_, err := places.Upsert(nil, "name", "Prospect Park")
+	if !errors.Is(err, ErrConflictWhere) {
+		t.Fatalf("Upsert without a SoftDeleteColumn error = %v, want %v", err, ErrConflictWhere)
+	}
+
+	places.SoftDeleteColumn = "deleted"
+
+	_, err = places.Upsert(nil, "name", "Prospect Park")
+	if err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+
+	if stampSQL != "UPDATE places SET created=? WHERE name=? AND deleted IS NULL AND (deleted IS NULL)" || len(stampArgs) != 2 || stampArgs[1] != "Prospect Park" {
+		t.Fatalf("created date stamp = %q %v", stampSQL, stampArgs)
+	}
+}
+
+// Reflection Scanning
+
+type placeDetail struct {
+	Name string `db:"name"`
+}
+
+type placeWithDetail struct {
+	ID int64 `db:"id"`
+	*placeDetail
+}
+
+func TestScanStructSkipsUnexportedEmbeddedPointer(t *testing.T) {
+	var (
+		place placeWithDetail
+	)
+
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		return fakeResponse{columns: placeColumns, rows: [][]driver.Value{{int64(1), "Prospect Park"}}}, nil
+	})
+
+	rows, err := databaseContext.Database.Query("SELECT * FROM places")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	defer rows.Close()
+
+	scanned, err := ScanStruct(rows, &place)
+	if err != nil || !scanned {
+		t.Fatalf("ScanStruct = %v, %v", scanned, err)
+	}
+
+	if place.ID != 1 || place.placeDetail != nil {
+		t.Fatalf("scanned %+v, want the id and no detail", place)
+	}
+}
+
+// Aggregates
+
+func TestAggregatesRejectUnknownColumns(t *testing.T) {
+	databaseContext, fake := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		return fakeResponse{columns: []string{"value"}, rows: [][]driver.Value{{int64(2)}}}, nil
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+
+	_, _, err := places.SumInt64(nil, "name) FROM places; --", nil)
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("SumInt64 error = %v, want %v", err, ErrUnknownColumn)
+	}
+
+	_, _, err = places.MaxTime(nil, "nope", nil)
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("MaxTime error = %v, want %v", err, ErrUnknownColumn)
+	}
+
+	err = places.StreamGroupCounts(nil, "nope", nil, func(key interface{}, count int64) error { return nil })
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("StreamGroupCounts error = %v, want %v", err, ErrUnknownColumn)
+	}
+
+	if len(fake.statements) != 0 {
+		t.Fatalf("want nothing run for unknown columns, ran %v", fake.statements)
+	}
+
+	count, err := places.Count(nil, nil, nil)
+	if err != nil || count != 2 {
+		t.Fatalf("Count = %d, %v", count, err)
+	}
+}
+
+// Schema Validation
+
+func TestValidateAllExpectsStampedColumns(t *testing.T) {
+	databaseContext, _ := newPlaceContext(func(query string, args []driver.Value) (fakeResponse, error) {
+		return fakeResponse{columns: placeColumns}, nil
+	})
+
+	places := databaseContext.EntityDescriptionForName("places")
+	places.CreatedDateColumn = "created"
+	places.SoftDeleteColumn = "deleted"
+	databaseContext.RegisterEntityDescription(places)
+
+	problems := databaseContext.ValidateAll(context.Background())
+	if len(problems) != 2 {
+		t.Fatalf("problems = %v, want created and deleted missing", problems)
+	}
+
+	for index, columnName := range []string{"created", "deleted"} {
+		if !errors.Is(problems[index], ErrSchemaMismatch) || !strings.HasSuffix(problems[index].Error(), "has no column "+columnName) {
+			t.Fatalf("problem %d = %v, want %s missing", index, problems[index], columnName)
+		}
+	}
+}
+
+// Statement Cache
+
+func TestStatementCacheIsBounded(t *testing.T) {
+	databaseContext, _ := newPlaceContext((&fakeInsertTable{}).respond)
+	defer databaseContext.Close()
+
+	for count := 1; count <= maxCachedStatements+10; count++ {
+		rows, err := databaseContext.queryCached(context.Background(), databaseContext.Database, "SELECT * FROM places WHERE id IN ("+placeholderList(count)+")")
+		if err != nil {
+			t.Fatalf("queryCached: %v", err)
+		}
+
+		rows.Close()
+	}
+
+	if len(databaseContext.statementCache) != maxCachedStatements {
+		t.Fatalf("cache holds %d statements, want %d", len(databaseContext.statementCache), maxCachedStatements)
+	}
+}
+
+// The fake parses nothing, so prepares/op stands in for the server-side
+// parsing a real database would repeat for every uncached read.
+func benchmarkFindEntity(b *testing.B, cacheReadStatements bool) {
+	databaseContext, fake := newPlaceContext((&fakeInsertTable{}).respond)
+	databaseContext.CacheReadStatements = cacheReadStatements
+	defer databaseContext.Close()
+
+	places := databaseContext.EntityDescriptionForName("places")
+
+	b.ResetTimer()
+
+	for iteration := 0; iteration < b.N; iteration++ {
+		_, err := places.FindEntity(nil, nil, int64(1))
+		if err != nil {
+			b.Fatalf("FindEntity: %v", err)
+		}
+	}
+
+	b.ReportMetric(float64(fake.prepares)/float64(b.N), "prepares/op")
+}
+
+func BenchmarkFindEntityUncached(b *testing.B) {
+	benchmarkFindEntity(b, false)
+}
+
+func BenchmarkFindEntityCached(b *testing.B) {
+	benchmarkFindEntity(b, true)
+}
@@ -0,0 +1,222 @@
+package bccdata
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// Column Type Coercion
+
+// ScanCoerced is for hand-written ScanFromRow implementations: it advances to
+// the next row and stores each column into the matching typed destination,
+// converting loosely typed driver values (SQLite's strings and integers) to
+// what the destination expects. It follows the ScanFromRow contract.
+func ScanCoerced(rows *sql.Rows, destinations ...interface{}) (bool, error) {
+	var (
+		databaseTypes []string
+		values        []interface{}
+		err           error
+	)
+
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+
+	_, databaseTypes, values, err = scanRawValues(rows)
+	if err != nil {
+		return false, err
+	}
+
+	if len(destinations) != len(values) {
+		return false, fmt.Errorf("bccdata: %d destinations for %d columns", len(destinations), len(values))
+	}
+
+	for index, destination := range destinations {
+		destinationValue := reflect.ValueOf(destination)
+		if destinationValue.Kind() != reflect.Ptr || destinationValue.IsNil() {
+			return false, fmt.Errorf("bccdata: destination %d is not a pointer", index)
+		}
+
+		err = assignValue(destinationValue.Elem(), values[index], databaseTypes[index])
+		if err != nil {
+			return false, fmt.Errorf("bccdata: column %d: %w", index, err)
+		}
+	}
+
+	return true, nil
+}
+
+// Reads the current row as raw driver values alongside each column's
+// database type name, as reported by rows.ColumnTypes.
+func scanRawValues(rows *sql.Rows) (columnNames []string, databaseTypes []string, values []interface{}, err error) {
+	var (
+		columnTypes []*sql.ColumnType
+		pointers    []interface{}
+	)
+
+	columnTypes, err = rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	columnNames = make([]string, len(columnTypes))
+	databaseTypes = make([]string, len(columnTypes))
+	values = make([]interface{}, len(columnTypes))
+	pointers = make([]interface{}, len(columnTypes))
+
+	for index, columnType := range columnTypes {
+		columnNames[index] = columnType.Name()
+		databaseTypes[index] = strings.ToUpper(columnType.DatabaseTypeName())
+		pointers[index] = &values[index]
+	}
+
+	err = rows.Scan(pointers...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return columnNames, databaseTypes, values, nil
+}
+
+func coerceValue(value interface{}, databaseType string, targetType reflect.Type) (interface{}, error) {
+	var (
+		rawValue reflect.Value
+	)
+
+	if bytes, ok := value.([]byte); ok && targetType.Kind() != reflect.Slice {
+		value = string(bytes)
+	}
+
+	rawValue = reflect.ValueOf(value)
+
+	if rawValue.Type().AssignableTo(targetType) {
+		return value, nil
+	}
+
+	if targetType == timeType {
+		return coerceTime(value, databaseType)
+	}
+
+	switch targetType.Kind() {
+	case reflect.String:
+		switch typedValue := value.(type) {
+		case int64:
+			return reflect.ValueOf(strconv.FormatInt(typedValue, 10)).Convert(targetType).Interface(), nil
+		case float64:
+			return reflect.ValueOf(strconv.FormatFloat(typedValue, 'f', -1, 64)).Convert(targetType).Interface(), nil
+		case bool:
+			return reflect.ValueOf(strconv.FormatBool(typedValue)).Convert(targetType).Interface(), nil
+		case time.Time:
+			return reflect.ValueOf(typedValue.Format(time.RFC3339Nano)).Convert(targetType).Interface(), nil
+		}
+
+	case reflect.Bool:
+		switch typedValue := value.(type) {
+		case int64:
+			return reflect.ValueOf(typedValue != 0).Convert(targetType).Interface(), nil
+		case string:
+			parsed, err := strconv.ParseBool(typedValue)
+			if err != nil {
+				return nil, err
+			}
+
+			return reflect.ValueOf(parsed).Convert(targetType).Interface(), nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch typedValue := value.(type) {
+		case string:
+			parsed, err := strconv.ParseInt(strings.TrimSpace(typedValue), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			return reflect.ValueOf(parsed).Convert(targetType).Interface(), nil
+		case bool:
+			if typedValue {
+				return reflect.ValueOf(1).Convert(targetType).Interface(), nil
+			}
+
+			return reflect.Zero(targetType).Interface(), nil
+		case float64:
+			if typedValue != float64(int64(typedValue)) {
+				return nil, fmt.Errorf("cannot assign non-integral %v to %s", typedValue, targetType)
+			}
+		case time.Time:
+			return reflect.ValueOf(typedValue.Unix()).Convert(targetType).Interface(), nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if typedValue, ok := value.(string); ok {
+			parsed, err := strconv.ParseUint(strings.TrimSpace(typedValue), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			return reflect.ValueOf(parsed).Convert(targetType).Interface(), nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if typedValue, ok := value.(string); ok {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(typedValue), 64)
+			if err != nil {
+				return nil, err
+			}
+
+			return reflect.ValueOf(parsed).Convert(targetType).Interface(), nil
+		}
+	}
+
+	// Converting an integer to a string yields a rune, never what a column
+	// holds, so that conversion is left to the cases above.
+	if rawValue.Type().ConvertibleTo(targetType) && targetType.Kind() != reflect.String {
+		return rawValue.Convert(targetType).Interface(), nil
+	}
+
+	if rawValue.Kind() == reflect.String && targetType.Kind() == reflect.String {
+		return rawValue.Convert(targetType).Interface(), nil
+	}
+
+	return nil, fmt.Errorf("cannot assign %T (%s) to %s", value, databaseType, targetType)
+}
+
+// Integers are read as Unix seconds, matching how Create stamps
+// CreatedDateColumn.
+func coerceTime(value interface{}, databaseType string) (interface{}, error) {
+	switch typedValue := value.(type) {
+	case int64:
+		return time.Unix(typedValue, 0), nil
+	case float64:
+		return time.Unix(int64(typedValue), 0), nil
+	case string:
+		if strings.Contains(databaseType, "INT") {
+			seconds, err := strconv.ParseInt(typedValue, 10, 64)
+			if err == nil {
+				return time.Unix(seconds, 0), nil
+			}
+		}
+
+		for _, layout := range timeLayouts {
+			parsed, err := time.Parse(layout, typedValue)
+			if err == nil {
+				return parsed, nil
+			}
+		}
+
+		return nil, fmt.Errorf("cannot parse %q (%s) as a time", typedValue, databaseType)
+	}
+
+	return nil, fmt.Errorf("cannot assign %T (%s) to time.Time", value, databaseType)
+}
@@ -0,0 +1,262 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type OpKind int
+
+const (
+	OpRead OpKind = iota
+	OpWrite
+)
+
+// Connection Routing
+
+// The ConnectionRouter is consulted first, and a nil answer falls through to
+// the default of ReadDatabase for reads, when one is set, and Database for
+// everything else. Context-level operations pass an empty entity name.
+func (databaseContext *DatabaseContext) connection(entityName string, operation OpKind) *sql.DB {
+	if databaseContext.ConnectionRouter != nil {
+		database := databaseContext.ConnectionRouter(entityName, operation)
+		if database != nil {
+			return database
+		}
+	}
+
+	if operation == OpRead && databaseContext.ReadDatabase != nil {
+		return databaseContext.ReadDatabase
+	}
+
+	return databaseContext.Database
+}
+
+// Statements run on the transaction when there is one, since it is already
+// bound to a connection; otherwise on the routed database.
+func (databaseContext *DatabaseContext) query(ctx context.Context, transaction *sql.Tx, entityName string, operation OpKind, querySQL string, args ...interface{}) (rows *sql.Rows, err error) {
+	querySQL, args, err = databaseContext.rewriteQuery(querySQL, args)
+	if err != nil {
+		return nil, err
+	}
+
+	querySQL = databaseContext.rebind(querySQL)
+
+	startTime := time.Now()
+	defer func() {
+		databaseContext.recordQuery(ctx, querySQL, args, time.Since(startTime), err)
+	}()
+
+	if transaction != nil {
+		return transaction.QueryContext(ctx, querySQL, args...)
+	}
+
+	if databaseContext.CacheReadStatements && operation == OpRead {
+		return databaseContext.queryCached(ctx, databaseContext.connection(entityName, operation), querySQL, args...)
+	}
+
+	return databaseContext.connection(entityName, operation).QueryContext(ctx, querySQL, args...)
+}
+
+func (databaseContext *DatabaseContext) exec(ctx context.Context, transaction *sql.Tx, entityName string, execSQL string, args ...interface{}) (result sql.Result, err error) {
+	execSQL, args, err = databaseContext.rewriteQuery(execSQL, args)
+	if err != nil {
+		return nil, err
+	}
+
+	execSQL = databaseContext.rebind(execSQL)
+
+	startTime := time.Now()
+	defer func() {
+		databaseContext.recordQuery(ctx, execSQL, args, time.Since(startTime), err)
+	}()
+
+	if transaction != nil {
+		return transaction.ExecContext(ctx, execSQL, args...)
+	}
+
+	return databaseContext.connection(entityName, OpWrite).ExecContext(ctx, execSQL, args...)
+}
+
+// Runs an INSERT and returns the new row's keyColumn, through a RETURNING
+// clause on dialects that have no LastInsertId.
+func (databaseContext *DatabaseContext) insertID(ctx context.Context, transaction *sql.Tx, entityName string, keyColumn string, insertSQL string, args ...interface{}) (objectID int64, err error) {
+	var (
+		ids []interface{}
+	)
+
+	ids, err = databaseContext.insertIDs(ctx, transaction, entityName, keyColumn, insertSQL, 1, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return ids[0].(int64), nil
+}
+
+// The multi-row form, returning rowCount ids in row order.
+func (databaseContext *DatabaseContext) insertIDs(ctx context.Context, transaction *sql.Tx, entityName string, keyColumn string, insertSQL string, rowCount int, args ...interface{}) (ids []interface{}, err error) {
+	var (
+		returningSQL string
+		result       sql.Result
+		lastInsertID int64
+		rows         *sql.Rows
+	)
+
+	returningSQL = databaseContext.dialect().InsertReturningID(insertSQL, databaseContext.quoteIdentifier(keyColumn))
+
+	if returningSQL == insertSQL {
+		result, err = databaseContext.exec(ctx, transaction, entityName, insertSQL, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		lastInsertID, err = result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+
+		firstID := databaseContext.dialect().FirstInsertID(lastInsertID, rowCount)
+		for offset := 0; offset < rowCount; offset++ {
+			ids = append(ids, firstID+int64(offset))
+		}
+
+		return ids, nil
+	}
+
+	rows, err = databaseContext.query(ctx, transaction, entityName, OpWrite, returningSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var objectID int64
+
+		err = rows.Scan(&objectID)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, objectID)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) != rowCount {
+		return nil, fmt.Errorf("bccdata: insert returned %d ids for %d rows", len(ids), rowCount)
+	}
+
+	return ids, nil
+}
+
+func (databaseContext *DatabaseContext) begin(ctx context.Context, entityName string, operation OpKind) (*sql.Tx, error) {
+	return databaseContext.connection(entityName, operation).BeginTx(ctx, nil)
+}
+
+// Statement Cache
+
+type statementCacheKey struct {
+	database *sql.DB
+	sql      string
+}
+
+// The most statements the read cache keeps open at once.
+const maxCachedStatements = 256
+
+// With CacheReadStatements set, reads outside a transaction run on a
+// statement prepared the first time its SQL is seen on that database and kept
+// until Close. A statement that differs only in how many values an IN list
+// holds is a separate entry, so once maxCachedStatements are held any new SQL
+// runs unprepared rather than growing the cache without bound. Reads in a
+// transaction are not cached, since the transaction's database is not known
+// here.
+func (databaseContext *DatabaseContext) queryCached(ctx context.Context, database *sql.DB, querySQL string, args ...interface{}) (rows *sql.Rows, err error) {
+	var (
+		cacheKey  statementCacheKey
+		statement *sql.Stmt
+		ok        bool
+	)
+
+	cacheKey = statementCacheKey{database: database, sql: querySQL}
+
+	databaseContext.statementCacheLock.Lock()
+	statement, ok = databaseContext.statementCache[cacheKey]
+	if !ok && len(databaseContext.statementCache) >= maxCachedStatements {
+		databaseContext.statementCacheLock.Unlock()
+		return database.QueryContext(ctx, querySQL, args...)
+	}
+
+	if !ok {
+		statement, err = database.PrepareContext(ctx, querySQL)
+		if err == nil {
+			if databaseContext.statementCache == nil {
+				databaseContext.statementCache = make(map[statementCacheKey]*sql.Stmt)
+			}
+
+			databaseContext.statementCache[cacheKey] = statement
+		}
+	}
+	databaseContext.statementCacheLock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return statement.QueryContext(ctx, args...)
+}
+
+// Closes the cached read statements; the databases themselves are the
+// caller's to close. The first error is returned once every statement has been
+// tried, and the cache starts over empty either way.
+func (databaseContext *DatabaseContext) Close() (err error) {
+	databaseContext.statementCacheLock.Lock()
+	defer databaseContext.statementCacheLock.Unlock()
+
+	for _, statement := range databaseContext.statementCache {
+		closeErr := statement.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	databaseContext.statementCache = nil
+
+	return err
+}
+
+// Pool Statistics
+
+// Routed connections are the router's to observe; these cover only Database
+// and ReadDatabase.
+func (databaseContext *DatabaseContext) Stats() sql.DBStats {
+	return databaseContext.Database.Stats()
+}
+
+// Zero when no ReadDatabase is set.
+func (databaseContext *DatabaseContext) ReadStats() sql.DBStats {
+	if databaseContext.ReadDatabase == nil {
+		return sql.DBStats{}
+	}
+
+	return databaseContext.ReadDatabase.Stats()
+}
+
+// Query Rewriting
+
+// Every generated statement passes through the QueryRewriter just before it
+// runs; an error from the rewriter aborts the statement. Statements prepared
+// ahead of time, such as InsertStatement, are rewritten when they are built by
+// BuildInsertStatement and not again on each Create. Rewriters always see ?
+// placeholders; the dialect's own are put in afterwards.
+func (databaseContext *DatabaseContext) rewriteQuery(statementSQL string, args []interface{}) (string, []interface{}, error) {
+	if databaseContext.QueryRewriter == nil {
+		return statementSQL, args, nil
+	}
+
+	return databaseContext.QueryRewriter(statementSQL, args)
+}
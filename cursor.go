@@ -0,0 +1,209 @@
+package bccdata
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor  = errors.New("bccdata: invalid cursor")
+	ErrInvalidLimit   = errors.New("bccdata: limit must be positive")
+	ErrNotKeyed       = errors.New("bccdata: entity does not implement KeyedEntity")
+	ErrNoCursorSecret = errors.New("bccdata: cursor pagination needs a CursorSecret")
+)
+
+type Cursor struct {
+	Values []interface{}
+}
+
+// Cursor Encoding
+
+// Tokens are the base64 JSON of the cursor values. When CursorSecret is set an
+// HMAC of that payload is appended, and DecodeCursor rejects any token whose
+// signature does not match. Without it tokens are unsigned, and anyone can
+// read or forge them.
+func (databaseContext *DatabaseContext) EncodeCursor(cursor Cursor) (token string, err error) {
+	var (
+		payload []byte
+	)
+
+	payload, err = json.Marshal(cursor.Values)
+	if err != nil {
+		return "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(payload)
+
+	if len(databaseContext.CursorSecret) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(databaseContext.cursorSignature(payload))
+	}
+
+	return token, nil
+}
+
+func (databaseContext *DatabaseContext) DecodeCursor(token string) (cursor Cursor, err error) {
+	var (
+		encodedPayload string
+		signature      string
+		signed         bool
+		payload        []byte
+		decodedSig     []byte
+		decoder        *json.Decoder
+	)
+
+	encodedPayload, signature, signed = strings.Cut(token, ".")
+
+	payload, err = base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	if len(databaseContext.CursorSecret) > 0 {
+		if !signed {
+			return cursor, ErrInvalidCursor
+		}
+
+		decodedSig, err = base64.RawURLEncoding.DecodeString(signature)
+		if err != nil || !hmac.Equal(decodedSig, databaseContext.cursorSignature(payload)) {
+			return cursor, ErrInvalidCursor
+		}
+	}
+
+	decoder = json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	err = decoder.Decode(&cursor.Values)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	// JSON numbers come back as json.Number; hand the driver an int64 where the
+	// value is integral so keys compare as numbers rather than strings.
+	for index, value := range cursor.Values {
+		number, ok := value.(json.Number)
+		if !ok {
+			continue
+		}
+
+		if integer, err := number.Int64(); err == nil {
+			cursor.Values[index] = integer
+		} else if float, err := number.Float64(); err == nil {
+			cursor.Values[index] = float
+		}
+	}
+
+	return cursor, nil
+}
+
+func (databaseContext *DatabaseContext) cursorSignature(payload []byte) []byte {
+	mac := hmac.New(sha256.New, databaseContext.CursorSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Cursor Pagination
+
+// Pages are keyed on the primary key in ascending order. The returned cursor is
+// empty once the last page has been read; entities must implement KeyedEntity
+// so the next cursor can be taken from the final row. Cursors handed to
+// clients must not be forgeable, so a context without a CursorSecret is
+// ErrNoCursorSecret.
+func (entityDescription *EntityDescription) FindPageByCursor(transaction *sql.Tx, clause *WhereClause, cursor string, limit int) (entities []Entity, nextCursor string, err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereClause     *WhereClause
+		decodedCursor   Cursor
+		whereSQL        string
+		args            []interface{}
+		selectColumns   string
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	databaseContext = entityDescription.Context
+	if len(databaseContext.CursorSecret) == 0 {
+		return nil, "", ErrNoCursorSecret
+	}
+
+	whereClause = clause.clone()
+
+	if cursor != "" {
+		decodedCursor, err = databaseContext.DecodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(decodedCursor.Values) != 1 {
+			return nil, "", ErrInvalidCursor
+		}
+
+		whereClause.And(entityDescription.PrimaryKey, ">", decodedCursor.Values[0])
+	}
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return nil, "", err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	selectColumns, err = entityDescription.selectColumns("")
+	if err != nil {
+		return nil, "", err
+	}
+
+	selectStatement, err = entityDescription.readSQL(selectColumns, whereSQL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	selectStatement += fmt.Sprintf(" ORDER BY %s LIMIT %d", databaseContext.quoteIdentifier(entityDescription.PrimaryKey), limit+1)
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, selectStatement, args...)
+
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entities, err = entityDescription.CreateFromRows(rows)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(entities) > limit {
+		entities = entities[:limit]
+
+		keyedEntity, ok := entities[limit-1].(KeyedEntity)
+		if !ok {
+			return nil, "", ErrNotKeyed
+		}
+
+		nextCursor, err = databaseContext.EncodeCursor(Cursor{Values: []interface{}{keyedEntity.PrimaryKeyValue()}})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = entityDescription.runAfterFind(entities)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return entities, nextCursor, nil
+}
@@ -0,0 +1,318 @@
+package bccdata
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var ErrLockNotSupported = errors.New("bccdata: lock mode not supported by dialect")
+
+// SharedLockClause returns the clause appended to a SELECT to take a shared
+// row lock, or ErrLockNotSupported. An empty clause with a nil error means the
+// dialect's transactions already hold a shared lock on what they read.
+// SkipLockedClause is the exclusive counterpart that also passes over rows
+// other transactions have locked.
+// MaxParameters is the most bound parameters one statement may carry.
+// UpsertClause follows an INSERT's VALUES list and turns a conflict on
+// conflictColumns into an update of updateColumns; both arrive quoted.
+// conflictWhere, when set, is a predicate naming a partial unique index.
+// SearchCondition is a full-text predicate on a quoted column with a single
+// placeholder for the search text.
+// FirstInsertID works out the first ID of a multi-row INSERT from the
+// LastInsertId the driver reports for it.
+// Placeholder is the nth bound parameter's marker, counting from 1; statements
+// are built with ? and rewritten to it just before they run.
+// InsertReturningID adapts an INSERT to return the new rows' keyColumn, which
+// arrives quoted, for dialects without LastInsertId; the rest return it
+// unchanged.
+type Dialect interface {
+	QuoteIdentifier(identifier string) string
+	SharedLockClause() (string, error)
+	SkipLockedClause() (string, error)
+	MaxParameters() int
+	UpsertClause(conflictColumns []string, conflictWhere string, updateColumns []string) string
+	SearchCondition(column string, configuration string) (string, error)
+	FirstInsertID(lastInsertID int64, rowCount int) int64
+	Placeholder(n int) string
+	InsertReturningID(insertSQL string, keyColumn string) string
+}
+
+// Room kept free in each chunk for parameters added by BeforeFind hooks.
+const reservedParameters = 16
+
+type SQLiteDialect struct{}
+
+type MySQLDialect struct{}
+
+type PostgresDialect struct{}
+
+func (dialect SQLiteDialect) QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+func (dialect MySQLDialect) QuoteIdentifier(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+func (dialect PostgresDialect) QuoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// SQLite locks the whole database while a transaction reads, so no clause is
+// needed to keep rows from changing underneath it.
+func (dialect SQLiteDialect) SharedLockClause() (string, error) {
+	return "", nil
+}
+
+func (dialect MySQLDialect) SharedLockClause() (string, error) {
+	return "LOCK IN SHARE MODE", nil
+}
+
+func (dialect PostgresDialect) SharedLockClause() (string, error) {
+	return "FOR SHARE", nil
+}
+
+// SQLite has no row locks. Its single writer means a second transaction
+// claiming the same row fails when it writes, rather than skipping ahead.
+func (dialect SQLiteDialect) SkipLockedClause() (string, error) {
+	return "", nil
+}
+
+// Needs MySQL 8.0 or later.
+func (dialect MySQLDialect) SkipLockedClause() (string, error) {
+	return "FOR UPDATE SKIP LOCKED", nil
+}
+
+func (dialect PostgresDialect) SkipLockedClause() (string, error) {
+	return "FOR UPDATE SKIP LOCKED", nil
+}
+
+// 999 is SQLite's historical SQLITE_MAX_VARIABLE_NUMBER, still the limit on
+// many system builds.
+func (dialect SQLiteDialect) MaxParameters() int {
+	return 999
+}
+
+func (dialect MySQLDialect) MaxParameters() int {
+	return 65535
+}
+
+func (dialect PostgresDialect) MaxParameters() int {
+	return 65535
+}
+
+func (dialect SQLiteDialect) UpsertClause(conflictColumns []string, conflictWhere string, updateColumns []string) string {
+	return excludedUpsertClause(conflictColumns, conflictWhere, updateColumns)
+}
+
+// MySQL has no conflict target, and no partial indexes for conflictWhere to
+// name; any unique key that collides triggers the update. With nothing to
+// update, a no-op assignment keeps the row as is.
+func (dialect MySQLDialect) UpsertClause(conflictColumns []string, conflictWhere string, updateColumns []string) string {
+	var (
+		assignments []string
+	)
+
+	for _, column := range updateColumns {
+		assignments = append(assignments, column+"=VALUES("+column+")")
+	}
+
+	if len(assignments) == 0 {
+		assignments = append(assignments, conflictColumns[0]+"="+conflictColumns[0])
+	}
+
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+}
+
+func (dialect PostgresDialect) UpsertClause(conflictColumns []string, conflictWhere string, updateColumns []string) string {
+	return excludedUpsertClause(conflictColumns, conflictWhere, updateColumns)
+}
+
+// SQLite and PostgreSQL share the ON CONFLICT form and its excluded row. Both
+// match a partial unique index only when the target repeats its predicate.
+func excludedUpsertClause(conflictColumns []string, conflictWhere string, updateColumns []string) string {
+	var (
+		conflictTarget string
+		assignments    []string
+	)
+
+	conflictTarget = "ON CONFLICT (" + strings.Join(conflictColumns, ", ") + ")"
+	if conflictWhere != "" {
+		conflictTarget += " WHERE " + conflictWhere
+	}
+
+	if len(updateColumns) == 0 {
+		return conflictTarget + " DO NOTHING"
+	}
+
+	for _, column := range updateColumns {
+		assignments = append(assignments, column+"=excluded."+column)
+	}
+
+	return conflictTarget + " DO UPDATE SET " + strings.Join(assignments, ", ")
+}
+
+// The column is an FTS5 table, or one of its columns, and the search text is
+// FTS5 query syntax.
+func (dialect SQLiteDialect) SearchCondition(column string, configuration string) (string, error) {
+	return column + " MATCH ?", nil
+}
+
+// Needs a FULLTEXT index on the column.
+func (dialect MySQLDialect) SearchCondition(column string, configuration string) (string, error) {
+	return "MATCH (" + column + ") AGAINST (? IN NATURAL LANGUAGE MODE)", nil
+}
+
+// The column holds a tsvector, and configuration names the text search
+// configuration it was built with, such as english. Search text is plain
+// words, as plainto_tsquery takes them.
+func (dialect PostgresDialect) SearchCondition(column string, configuration string) (string, error) {
+	if configuration == "" {
+		return column + " @@ plainto_tsquery(?)", nil
+	}
+
+	return column + " @@ plainto_tsquery('" + strings.ReplaceAll(configuration, "'", "''") + "', ?)", nil
+}
+
+// SQLite reports the last row's ID; one writer at a time keeps a statement's
+// rows consecutive.
+func (dialect SQLiteDialect) FirstInsertID(lastInsertID int64, rowCount int) int64 {
+	return lastInsertID - int64(rowCount) + 1
+}
+
+// MySQL reports the first row's ID, and InnoDB hands a single statement
+// consecutive IDs unless innodb_autoinc_lock_mode is 2.
+func (dialect MySQLDialect) FirstInsertID(lastInsertID int64, rowCount int) int64 {
+	return lastInsertID
+}
+
+// PostgreSQL drivers do not report LastInsertId at all.
+func (dialect PostgresDialect) FirstInsertID(lastInsertID int64, rowCount int) int64 {
+	return lastInsertID
+}
+
+func (dialect SQLiteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (dialect MySQLDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (dialect PostgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (dialect SQLiteDialect) InsertReturningID(insertSQL string, keyColumn string) string {
+	return insertSQL
+}
+
+func (dialect MySQLDialect) InsertReturningID(insertSQL string, keyColumn string) string {
+	return insertSQL
+}
+
+func (dialect PostgresDialect) InsertReturningID(insertSQL string, keyColumn string) string {
+	return insertSQL + " RETURNING " + keyColumn
+}
+
+// Context Helpers
+
+func (databaseContext *DatabaseContext) dialect() Dialect {
+	if databaseContext.Dialect == nil {
+		return SQLiteDialect{}
+	}
+
+	return databaseContext.Dialect
+}
+
+// Qualified names such as "lists.id" are quoted one part at a time. Nothing is
+// quoted unless QuoteIdentifiers is set, which keeps the generated SQL exactly
+// as it was for existing callers.
+func (databaseContext *DatabaseContext) quoteIdentifier(identifier string) string {
+	var (
+		dialect Dialect
+		parts   []string
+	)
+
+	if !databaseContext.QuoteIdentifiers {
+		return identifier
+	}
+
+	dialect = databaseContext.dialect()
+	parts = strings.Split(identifier, ".")
+
+	for index, part := range parts {
+		if part != "*" {
+			parts[index] = dialect.QuoteIdentifier(part)
+		}
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func (databaseContext *DatabaseContext) quoteIdentifiers(identifiers []string) (quotedIdentifiers []string) {
+	for _, identifier := range identifiers {
+		quotedIdentifiers = append(quotedIdentifiers, databaseContext.quoteIdentifier(identifier))
+	}
+
+	return quotedIdentifiers
+}
+
+func (databaseContext *DatabaseContext) parameterChunkSize() int {
+	chunkSize := databaseContext.dialect().MaxParameters() - reservedParameters
+	if chunkSize < 1 {
+		return 1
+	}
+
+	return chunkSize
+}
+
+func chunkValues(values []interface{}, chunkSize int) (chunks [][]interface{}) {
+	for len(values) > chunkSize {
+		chunks = append(chunks, values[:chunkSize])
+		values = values[chunkSize:]
+	}
+
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+
+	return chunks
+}
+
+// Each ? outside a quoted string or identifier becomes the dialect's
+// placeholder for its position. Dialects that use ? get the statement back as
+// is.
+func (databaseContext *DatabaseContext) rebind(statementSQL string) string {
+	var (
+		dialect Dialect
+		builder strings.Builder
+		quote   rune
+		count   int
+	)
+
+	dialect = databaseContext.dialect()
+	if dialect.Placeholder(1) == "?" {
+		return statementSQL
+	}
+
+	for _, character := range statementSQL {
+		switch {
+		case quote != 0:
+			if character == quote {
+				quote = 0
+			}
+		case character == '\'' || character == '"' || character == '`':
+			quote = character
+		case character == '?':
+			count++
+			builder.WriteString(dialect.Placeholder(count))
+			continue
+		}
+
+		builder.WriteRune(character)
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,112 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Context Comparison
+
+// Compares every row of the entity in its own database against the same table
+// in other's, by primary key. added holds the keys only other has, removed
+// those other lacks, and changed those whose VersionColumn, or
+// UpdatedDateColumn when there is no version, differs; with neither set,
+// changed is always empty. Keys come back in primary key order, taken from
+// whichever database has the row. BeforeFind is not applied.
+func (entityDescription *EntityDescription) Diff(other *DatabaseContext) (added, changed, removed []interface{}, err error) {
+	var (
+		versionColumn string
+		localKeys     []interface{}
+		localVersions map[string]interface{}
+		otherKeys     []interface{}
+		otherVersions map[string]interface{}
+	)
+
+	versionColumn = entityDescription.VersionColumn
+	if versionColumn == "" {
+		versionColumn = entityDescription.UpdatedDateColumn
+	}
+
+	localKeys, localVersions, err = entityDescription.Context.rowVersions(entityDescription, versionColumn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	otherKeys, otherVersions, err = other.rowVersions(entityDescription, versionColumn)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, key := range localKeys {
+		otherVersion, ok := otherVersions[keyString(key)]
+		if !ok {
+			removed = append(removed, key)
+		} else if versionColumn != "" && keyString(otherVersion) != keyString(localVersions[keyString(key)]) {
+			changed = append(changed, key)
+		}
+	}
+
+	for _, key := range otherKeys {
+		if _, ok := localVersions[keyString(key)]; !ok {
+			added = append(added, key)
+		}
+	}
+
+	return added, changed, removed, nil
+}
+
+// Versions are keyed by keyString of the primary key, and are nil throughout
+// when versionColumn is empty. The statement is built with this context's
+// quoting, as the two databases may not share a dialect.
+func (databaseContext *DatabaseContext) rowVersions(entityDescription *EntityDescription, versionColumn string) (keys []interface{}, versions map[string]interface{}, err error) {
+	var (
+		columns         []string
+		source          string
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	columns = []string{databaseContext.quoteIdentifier(entityDescription.PrimaryKey)}
+	if versionColumn != "" {
+		columns = append(columns, databaseContext.quoteIdentifier(versionColumn))
+	}
+
+	source = entityDescription.SourceSQL
+	if source == "" {
+		source = databaseContext.quoteIdentifier(entityDescription.TableName)
+	}
+
+	selectStatement = fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", strings.Join(columns, ", "), source, columns[0])
+
+	rows, err = databaseContext.query(context.Background(), nil, entityDescription.Name, OpRead, selectStatement)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	versions = make(map[string]interface{})
+
+	for rows.Next() {
+		var key, version interface{}
+
+		if versionColumn != "" {
+			err = rows.Scan(&key, &version)
+		} else {
+			err = rows.Scan(&key)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if bytes, ok := key.([]byte); ok {
+			key = string(bytes)
+		}
+
+		keys = append(keys, key)
+		versions[keyString(key)] = version
+	}
+
+	return keys, versions, rows.Err()
+}
@@ -0,0 +1,56 @@
+package bccdata
+
+// Write Hooks
+
+// Write hooks run inside the write's transaction, Before hooks ahead of the
+// statement and After hooks once it has succeeded but before the commit, so
+// an error from either rolls the write back. A write handed a transaction
+// leaves the rollback to its caller.
+
+func (entityDescription *EntityDescription) runBeforeCreate(args []interface{}) error {
+	if entityDescription.BeforeCreate == nil {
+		return nil
+	}
+
+	return entityDescription.BeforeCreate(args)
+}
+
+func (entityDescription *EntityDescription) runAfterCreate(entity Entity) error {
+	if entityDescription.AfterCreate == nil {
+		return nil
+	}
+
+	return entityDescription.AfterCreate(entity)
+}
+
+func (entityDescription *EntityDescription) runBeforeUpdate(id interface{}, fields map[string]interface{}) error {
+	if entityDescription.BeforeUpdate == nil {
+		return nil
+	}
+
+	return entityDescription.BeforeUpdate(id, fields)
+}
+
+func (entityDescription *EntityDescription) runAfterUpdate(entity Entity) error {
+	if entityDescription.AfterUpdate == nil {
+		return nil
+	}
+
+	return entityDescription.AfterUpdate(entity)
+}
+
+func (entityDescription *EntityDescription) runBeforeDelete(id interface{}) error {
+	if entityDescription.BeforeDelete == nil {
+		return nil
+	}
+
+	return entityDescription.BeforeDelete(id)
+}
+
+func (entityDescription *EntityDescription) runAfterDelete(id interface{}) error {
+	if entityDescription.AfterDelete == nil {
+		return nil
+	}
+
+	return entityDescription.AfterDelete(id)
+}
@@ -0,0 +1,99 @@
+package bccdata
+
+import (
+	"context"
+	"sync"
+)
+
+type identityMapContextKey struct{}
+
+type identityKey struct {
+	entityName string
+	key        string
+}
+
+type IdentityMap struct {
+	mutex    sync.Mutex
+	entities map[identityKey]Entity
+}
+
+// Identity Mapping
+
+// Finders run with the returned context, or one derived from it, hand back the
+// instance already loaded through it for any row seen before, keyed by entity
+// name and PrimaryKeyValue, rather than a fresh one. The first instance loaded
+// wins and is not refreshed by later reads, short of FindOptions.SkipCache, so
+// a map should live no longer than the request or transaction it serves.
+// Creates and updates run with the context map the row they read back in its
+// place, and deletes drop it. Entities that do not implement KeyedEntity are
+// always fresh, as is everything read without such a context.
+func WithIdentityMap(ctx context.Context) (context.Context, *IdentityMap) {
+	identityMap := &IdentityMap{entities: make(map[identityKey]Entity)}
+	return context.WithValue(ctx, identityMapContextKey{}, identityMap), identityMap
+}
+
+func IdentityMapFromContext(ctx context.Context) *IdentityMap {
+	identityMap, _ := ctx.Value(identityMapContextKey{}).(*IdentityMap)
+	return identityMap
+}
+
+// Replaces each entity in place with the instance already mapped for its key,
+// mapping those not seen before. A nil map leaves the entities alone.
+func (identityMap *IdentityMap) deduplicate(entityName string, entities []Entity) {
+	if identityMap == nil {
+		return
+	}
+
+	identityMap.mutex.Lock()
+	defer identityMap.mutex.Unlock()
+
+	for index, entity := range entities {
+		keyedEntity, ok := entity.(KeyedEntity)
+		if !ok {
+			continue
+		}
+
+		mapKey := identityKey{entityName: entityName, key: keyString(keyedEntity.PrimaryKeyValue())}
+
+		if mappedEntity, ok := identityMap.entities[mapKey]; ok {
+			entities[index] = mappedEntity
+		} else {
+			identityMap.entities[mapKey] = entity
+		}
+	}
+}
+
+// Maps each entity in place of any instance already mapped for its key.
+func (identityMap *IdentityMap) refresh(entityName string, entities []Entity) {
+	if identityMap == nil {
+		return
+	}
+
+	identityMap.mutex.Lock()
+	defer identityMap.mutex.Unlock()
+
+	for _, entity := range entities {
+		if keyedEntity, ok := entity.(KeyedEntity); ok {
+			identityMap.entities[identityKey{entityName: entityName, key: keyString(keyedEntity.PrimaryKeyValue())}] = entity
+		}
+	}
+}
+
+func (identityMap *IdentityMap) evict(entityName string, key interface{}) {
+	if identityMap == nil {
+		return
+	}
+
+	identityMap.mutex.Lock()
+	defer identityMap.mutex.Unlock()
+
+	delete(identityMap.entities, identityKey{entityName: entityName, key: keyString(key)})
+}
+
+// Drops every mapped instance, so the next read of each row is fresh.
+func (identityMap *IdentityMap) Clear() {
+	identityMap.mutex.Lock()
+	defer identityMap.mutex.Unlock()
+
+	identityMap.entities = make(map[identityKey]Entity)
+}
@@ -0,0 +1,52 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Zero values leave the driver's and database/sql's defaults in place.
+type Options struct {
+	Dialect         Dialect
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	QueryTimeout    time.Duration
+}
+
+// Database Context
+
+// A DatabaseContext built as a struct literal works as it always has; this
+// only gathers the pool settings in one place and applies them to database.
+func NewDatabaseContext(database *sql.DB, options Options) *DatabaseContext {
+	if options.MaxOpenConns != 0 {
+		database.SetMaxOpenConns(options.MaxOpenConns)
+	}
+
+	if options.MaxIdleConns != 0 {
+		database.SetMaxIdleConns(options.MaxIdleConns)
+	}
+
+	if options.ConnMaxLifetime != 0 {
+		database.SetConnMaxLifetime(options.ConnMaxLifetime)
+	}
+
+	return &DatabaseContext{
+		Database:     database,
+		Dialect:      options.Dialect,
+		QueryTimeout: options.QueryTimeout,
+	}
+}
+
+// QueryTimeout bounds the *Context creators, finders and writers, and the
+// plain methods that call them, when they are handed a context that can never
+// be cancelled, such as context.Background(). A caller's own deadline or
+// cancellation always wins.
+func (databaseContext *DatabaseContext) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if databaseContext.QueryTimeout <= 0 || ctx.Done() != nil {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, databaseContext.QueryTimeout)
+}
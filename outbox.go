@@ -0,0 +1,41 @@
+package bccdata
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoOutbox            = errors.New("bccdata: no outbox entity registered")
+	ErrTransactionRequired = errors.New("bccdata: a transaction is required")
+)
+
+// Transactional Outbox
+
+// The event row is written into the caller's transaction, so it commits or
+// rolls back together with the state change it describes. A nil transaction
+// is refused rather than silently committing the event on its own.
+func (databaseContext *DatabaseContext) WithOutbox(transaction *sql.Tx, event map[string]interface{}) (err error) {
+	var (
+		outboxDescription EntityDescription
+		ok                bool
+	)
+
+	if transaction == nil {
+		return ErrTransactionRequired
+	}
+
+	if databaseContext.OutboxEntityName == "" {
+		return ErrNoOutbox
+	}
+
+	outboxDescription, ok = databaseContext.lookupEntityDescription(databaseContext.OutboxEntityName)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEntity, databaseContext.OutboxEntityName)
+	}
+
+	_, err = outboxDescription.CreateNamed(transaction, event)
+
+	return err
+}
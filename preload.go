@@ -0,0 +1,315 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+type PreloadManager struct {
+	databaseContext *DatabaseContext
+	mutex           sync.Mutex
+	requests        []preloadRequest
+}
+
+type preloadRequest struct {
+	entityName       string
+	relationshipName string
+	sourceKey        interface{}
+	assign           func([]Entity)
+}
+
+// Batched Relationship Loading
+
+// Loads a join-table relationship for many source rows with two queries, one
+// over the join table and one over the target table, instead of one query per
+// source. Key lists longer than the dialect's parameter limit are split across
+// several queries of each kind. The result lines up with sourceKeys. Target
+// entities must implement KeyedEntity, and the relationship's TargetKey must be
+// the target's primary key, so the join rows can be matched back to them.
+func (entityDescription *EntityDescription) FindRelatedEntitiesBatched(transaction *sql.Tx, relationshipName string, sourceKeys []interface{}) (related [][]Entity, err error) {
+	return entityDescription.FindRelatedEntitiesBatchedContext(context.Background(), transaction, relationshipName, sourceKeys)
+}
+
+// Runs in the transaction carried by ctx when none is passed, and targets are
+// deduplicated through the IdentityMap carried by ctx, if any.
+func (entityDescription *EntityDescription) FindRelatedEntitiesBatchedContext(ctx context.Context, transaction *sql.Tx, relationshipName string, sourceKeys []interface{}) (related [][]Entity, err error) {
+	var (
+		databaseContext         *DatabaseContext
+		relationship            EntityRelationship
+		targetEntityDescription EntityDescription
+		uniqueSourceKeys        []interface{}
+		targetKeysBySource      map[string][]string
+		uniqueTargetKeys        []interface{}
+		seenKeys                map[string]bool
+		chunkSize               int
+		joinRows                [][2]interface{}
+		targets                 []Entity
+		chunkTargets            []Entity
+		targetsByKey            map[string]Entity
+	)
+
+	ctx, cancel := entityDescription.Context.withQueryTimeout(ctx)
+	defer cancel()
+
+	transaction = contextTransaction(ctx, transaction)
+
+	related = make([][]Entity, len(sourceKeys))
+	if len(sourceKeys) == 0 {
+		return related, nil
+	}
+
+	databaseContext = entityDescription.Context
+
+	relationship, err = entityDescription.joinRelationship(relationshipName)
+	if err != nil {
+		return nil, err
+	}
+
+	targetEntityDescription = databaseContext.EntityDescriptionForName(relationship.EntityName)
+
+	seenKeys = make(map[string]bool)
+	for _, sourceKey := range sourceKeys {
+		if !seenKeys[keyString(sourceKey)] {
+			seenKeys[keyString(sourceKey)] = true
+			uniqueSourceKeys = append(uniqueSourceKeys, sourceKey)
+		}
+	}
+
+	chunkSize = databaseContext.parameterChunkSize()
+	targetKeysBySource = make(map[string][]string)
+	seenKeys = make(map[string]bool)
+
+	for _, sourceChunk := range chunkValues(uniqueSourceKeys, chunkSize) {
+		joinRows, err = entityDescription.queryJoinRows(ctx, transaction, relationship, sourceChunk)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, joinRow := range joinRows {
+			sourceKey, targetKey := joinRow[0], joinRow[1]
+
+			targetKeysBySource[keyString(sourceKey)] = append(targetKeysBySource[keyString(sourceKey)], keyString(targetKey))
+
+			if !seenKeys[keyString(targetKey)] {
+				seenKeys[keyString(targetKey)] = true
+				uniqueTargetKeys = append(uniqueTargetKeys, targetKey)
+			}
+		}
+	}
+
+	for _, targetChunk := range chunkValues(uniqueTargetKeys, chunkSize) {
+		chunkTargets, err = targetEntityDescription.selectEntities(ctx, transaction, Where(relationship.TargetKey, "IN", targetChunk), FindOptions{}, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, chunkTargets...)
+	}
+
+	targetsByKey = make(map[string]Entity, len(targets))
+	for _, target := range targets {
+		keyedTarget, ok := target.(KeyedEntity)
+		if !ok {
+			return nil, ErrNotKeyed
+		}
+
+		targetsByKey[keyString(keyedTarget.PrimaryKeyValue())] = target
+	}
+
+	for index, sourceKey := range sourceKeys {
+		for _, targetKey := range targetKeysBySource[keyString(sourceKey)] {
+			if target, ok := targetsByKey[targetKey]; ok {
+				related[index] = append(related[index], target)
+			}
+		}
+	}
+
+	return related, nil
+}
+
+// Returns (source key, target key) pairs from the join table.
+func (entityDescription *EntityDescription) queryJoinRows(ctx context.Context, transaction *sql.Tx, relationship EntityRelationship, sourceKeys []interface{}) (joinRows [][2]interface{}, err error) {
+	var (
+		databaseContext *DatabaseContext
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	databaseContext = entityDescription.Context
+
+	selectStatement = fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IN (%s)", databaseContext.quoteIdentifier(relationship.SourceKey), databaseContext.quoteIdentifier(relationship.ForeignKey), databaseContext.quoteIdentifier(relationship.JoinTableName), databaseContext.quoteIdentifier(relationship.SourceKey), placeholderList(len(sourceKeys)))
+
+	rows, err = databaseContext.query(ctx, transaction, entityDescription.Name, OpRead, selectStatement, sourceKeys...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var joinRow [2]interface{}
+
+		err = rows.Scan(&joinRow[0], &joinRow[1])
+		if err != nil {
+			return nil, err
+		}
+
+		joinRows = append(joinRows, joinRow)
+	}
+
+	return joinRows, rows.Err()
+}
+
+// Keys arrive as whatever type the caller or driver chose (int against int64,
+// string against []byte), so they are compared by their printed form.
+func keyString(key interface{}) string {
+	if bytes, ok := key.([]byte); ok {
+		return string(bytes)
+	}
+
+	return fmt.Sprint(key)
+}
+
+// Lazy Relationship Loading
+
+// Each found entity gets a loader per name in LazyRelationships that runs
+// FindRelatedEntity for it on the first call, outside any transaction, and
+// returns the same result on every call after. The entities must implement
+// LazyRelationshipEntity.
+func (entityDescription *EntityDescription) attachLazyLoaders(entities []Entity) error {
+	var (
+		relationship EntityRelationship
+		ok           bool
+	)
+
+	if len(entityDescription.LazyRelationships) == 0 {
+		return nil
+	}
+
+	for _, relationshipName := range entityDescription.LazyRelationships {
+		relationship, ok = entityDescription.Relationships[relationshipName]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownRelationship, relationshipName)
+		}
+
+		if relationship.kind() == ManyToMany && relationship.SourceKey == "" {
+			return fmt.Errorf("%w: %s", ErrMissingSourceKey, relationshipName)
+		}
+	}
+
+	for _, entity := range entities {
+		lazyEntity, ok := entity.(LazyRelationshipEntity)
+		if !ok {
+			return fmt.Errorf("bccdata: %s has LazyRelationships but %T does not implement LazyRelationshipEntity", entityDescription.Name, entity)
+		}
+
+		for _, relationshipName := range entityDescription.LazyRelationships {
+			lazyEntity.SetRelationshipLoader(relationshipName, entityDescription.lazyLoader(entityDescription.Relationships[relationshipName], lazyEntity.PrimaryKeyValue()))
+		}
+	}
+
+	return nil
+}
+
+func (entityDescription *EntityDescription) lazyLoader(relationship EntityRelationship, sourceKey interface{}) func() ([]Entity, error) {
+	var (
+		once     sync.Once
+		entities []Entity
+		err      error
+	)
+
+	return func() ([]Entity, error) {
+		once.Do(func() {
+			entities, err = entityDescription.FindRelatedEntity(nil, relationship.EntityName, relationship.SourceKey, sourceKey)
+		})
+
+		return entities, err
+	}
+}
+
+// Preload Manager
+
+func (databaseContext *DatabaseContext) NewPreloadManager() *PreloadManager {
+	return &PreloadManager{databaseContext: databaseContext}
+}
+
+// Queues a relationship load; assign receives the related entities when Flush
+// runs. Requests may be made from several goroutines.
+func (preloadManager *PreloadManager) Request(entityName string, relationshipName string, sourceKey interface{}, assign func([]Entity)) {
+	preloadManager.mutex.Lock()
+	defer preloadManager.mutex.Unlock()
+
+	preloadManager.requests = append(preloadManager.requests, preloadRequest{
+		entityName:       entityName,
+		relationshipName: relationshipName,
+		sourceKey:        sourceKey,
+		assign:           assign,
+	})
+}
+
+// Issues one batched load per distinct entity and relationship pair among the
+// queued requests, then hands each request its share of the results. The
+// queue is emptied whether or not the loads succeed.
+func (preloadManager *PreloadManager) Flush(transaction *sql.Tx) (err error) {
+	return preloadManager.FlushContext(context.Background(), transaction)
+}
+
+// Like Flush, with the loads bound to ctx and run in its transaction when none
+// is passed, and deduplicated through its IdentityMap, if any.
+func (preloadManager *PreloadManager) FlushContext(ctx context.Context, transaction *sql.Tx) (err error) {
+	var (
+		requests     []preloadRequest
+		groupOrder   []string
+		groups       map[string][]preloadRequest
+		sourceKeys   []interface{}
+		related      [][]Entity
+		entityLookup EntityDescription
+		ok           bool
+	)
+
+	ctx, cancel := preloadManager.databaseContext.withQueryTimeout(ctx)
+	defer cancel()
+
+	transaction = contextTransaction(ctx, transaction)
+
+	preloadManager.mutex.Lock()
+	requests = preloadManager.requests
+	preloadManager.requests = nil
+	preloadManager.mutex.Unlock()
+
+	groups = make(map[string][]preloadRequest)
+	for _, request := range requests {
+		groupKey := request.entityName + "\x00" + request.relationshipName
+		if _, ok = groups[groupKey]; !ok {
+			groupOrder = append(groupOrder, groupKey)
+		}
+
+		groups[groupKey] = append(groups[groupKey], request)
+	}
+
+	for _, groupKey := range groupOrder {
+		group := groups[groupKey]
+
+		entityLookup, ok = preloadManager.databaseContext.lookupEntityDescription(group[0].entityName)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownEntity, group[0].entityName)
+		}
+
+		sourceKeys = sourceKeys[:0]
+		for _, request := range group {
+			sourceKeys = append(sourceKeys, request.sourceKey)
+		}
+
+		related, err = entityLookup.FindRelatedEntitiesBatchedContext(ctx, transaction, group[0].relationshipName, sourceKeys)
+		if err != nil {
+			return err
+		}
+
+		for index, request := range group {
+			request.assign(related[index])
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+)
+
+// A Query collects conditions and ordering for a find. Columns are checked
+// against the description as they are added, and the first bad one is
+// returned when the query runs.
+type Query struct {
+	entityDescription *EntityDescription
+	whereClause       *WhereClause
+	options           FindOptions
+	err               error
+}
+
+// Query Building
+
+func (entityDescription *EntityDescription) Query() *Query {
+	return &Query{entityDescription: entityDescription, whereClause: &WhereClause{}}
+}
+
+func (query *Query) Where(column string, operator string, value interface{}) *Query {
+	return query.And(column, operator, value)
+}
+
+func (query *Query) And(column string, operator string, value interface{}) *Query {
+	if query.err == nil {
+		query.err = query.entityDescription.checkKnownColumn(column)
+	}
+
+	query.whereClause.And(column, operator, value)
+
+	return query
+}
+
+func (query *Query) OrderBy(column string, descending bool) *Query {
+	query.options.OrderBy = append(query.options.OrderBy, OrderBy{Column: column, Descending: descending})
+	return query
+}
+
+// Query Execution
+
+func (query *Query) All(transaction *sql.Tx) (entities []Entity, err error) {
+	return query.AllContext(context.Background(), transaction)
+}
+
+func (query *Query) AllContext(ctx context.Context, transaction *sql.Tx) (entities []Entity, err error) {
+	if query.err != nil {
+		return nil, query.err
+	}
+
+	return query.entityDescription.FindEntitiesWithOptionsContext(ctx, transaction, query.whereClause, query.options)
+}
+
+// The first row in the query's order, or ErrNotFound.
+func (query *Query) First(transaction *sql.Tx) (entity Entity, err error) {
+	return query.FirstContext(context.Background(), transaction)
+}
+
+func (query *Query) FirstContext(ctx context.Context, transaction *sql.Tx) (entity Entity, err error) {
+	var (
+		options FindOptions
+	)
+
+	if query.err != nil {
+		return nil, query.err
+	}
+
+	options = query.options
+	options.Match = FirstMatch
+
+	return query.entityDescription.FindEntityWithOptionsContext(ctx, transaction, query.whereClause, options)
+}
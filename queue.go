@@ -0,0 +1,129 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidOrder = errors.New("bccdata: order must be a column optionally followed by ASC or DESC")
+
+// Queue Claims
+
+// Locks and returns the first row matching clause in orderBy order, passing
+// over rows other transactions hold, so concurrent workers each claim a
+// different row. The lock lasts until transaction ends, which is where the
+// caller marks the row as taken. orderBy is a known column with an optional
+// ASC or DESC and defaults to the primary key. A nil entity means nothing was
+// free.
+func (entityDescription *EntityDescription) ClaimNext(transaction *sql.Tx, clause *WhereClause, orderBy string) (entity Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereClause     *WhereClause
+		orderSQL        string
+		lockSQL         string
+		whereSQL        string
+		args            []interface{}
+		selectColumns   string
+		selectStatement string
+		rows            *sql.Rows
+		entities        []Entity
+	)
+
+	if transaction == nil {
+		return nil, ErrTransactionRequired
+	}
+
+	databaseContext = entityDescription.Context
+	whereClause = clause.clone()
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	orderSQL, err = entityDescription.orderSQL(orderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	lockSQL, err = databaseContext.dialect().SkipLockedClause()
+	if err != nil {
+		return nil, err
+	}
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, args, err = whereClause.whereSQL(databaseContext, "")
+	if err != nil {
+		return nil, err
+	}
+
+	selectColumns, err = entityDescription.selectColumns("")
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement = fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s LIMIT 1", selectColumns, databaseContext.quoteIdentifier(entityDescription.TableName), whereSQL, orderSQL)
+	if lockSQL != "" {
+		selectStatement += " " + lockSQL
+	}
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpWrite, selectStatement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entities, err = entityDescription.CreateFromRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = entityDescription.runAfterFind(entities)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entities) > 0 {
+		entity = entities[0]
+	}
+
+	return entity, nil
+}
+
+func (entityDescription *EntityDescription) orderSQL(orderBy string) (string, error) {
+	var (
+		fields []string
+		order  OrderBy
+	)
+
+	fields = strings.Fields(orderBy)
+	if len(fields) == 0 {
+		return entityDescription.Context.quoteIdentifier(entityDescription.PrimaryKey), nil
+	}
+
+	if len(fields) > 2 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidOrder, orderBy)
+	}
+
+	order.Column = fields[0]
+
+	if len(fields) == 2 {
+		direction := strings.ToUpper(fields[1])
+		if direction != "ASC" && direction != "DESC" {
+			return "", fmt.Errorf("%w: %q", ErrInvalidOrder, orderBy)
+		}
+
+		order.Descending = direction == "DESC"
+	}
+
+	orderSQL, err := entityDescription.orderBySQL([]OrderBy{order})
+
+	return strings.TrimPrefix(orderSQL, " ORDER BY "), err
+}
@@ -0,0 +1,246 @@
+package bccdata
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// A NameMapper turns a Go field name, or a column name the package uses on
+// its own such as id, into the name the database uses.
+type NameMapper func(name string) string
+
+type columnValue struct {
+	value        interface{}
+	databaseType string
+}
+
+// Reflection Scanning
+
+// ScanStruct follows the ScanFromRow contract, so an entity can implement
+// ScanFromRow by handing itself to it. Exported fields are matched to columns
+// by their `db` tag, or by field name when untagged; `db:"-"` skips a field.
+// Struct and pointer-to-struct fields tagged `dbprefix:"list_"` are filled from
+// the columns carrying that prefix, which is how aliased join columns land in
+// a nested entity. A nested pointer is left nil when all of its columns are
+// NULL, as they are for an unmatched outer join. An embedded pointer to an
+// unexported struct cannot be set, so it is skipped.
+func ScanStruct(rows *sql.Rows, destination interface{}) (bool, error) {
+	return ScanStructMapped(rows, destination, nil)
+}
+
+// Like ScanStruct, but untagged field names go through mapper to find their
+// column. Tags and prefixes are used as written.
+func ScanStructMapped(rows *sql.Rows, destination interface{}, mapper NameMapper) (bool, error) {
+	var (
+		destinationValue reflect.Value
+		columnNames      []string
+		databaseTypes    []string
+		values           []interface{}
+		columnValues     map[string]columnValue
+		err              error
+	)
+
+	destinationValue = reflect.ValueOf(destination)
+	if destinationValue.Kind() != reflect.Ptr || destinationValue.Elem().Kind() != reflect.Struct {
+		return false, fmt.Errorf("bccdata: ScanStruct needs a pointer to a struct, got %T", destination)
+	}
+
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+
+	columnNames, databaseTypes, values, err = scanRawValues(rows)
+	if err != nil {
+		return false, err
+	}
+
+	columnValues = make(map[string]columnValue, len(columnNames))
+	for index, columnName := range columnNames {
+		columnValues[strings.ToLower(columnName)] = columnValue{value: values[index], databaseType: databaseTypes[index]}
+	}
+
+	_, err = assignStruct(destinationValue.Elem(), "", columnValues, mapper)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func assignStruct(structValue reflect.Value, prefix string, columnValues map[string]columnValue, mapper NameMapper) (assigned bool, err error) {
+	var (
+		structType reflect.Type
+	)
+
+	structType = structValue.Type()
+
+	for index := 0; index < structType.NumField(); index++ {
+		field := structType.Field(index)
+		fieldValue := structValue.Field(index)
+
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		columnName := field.Tag.Get("db")
+		if columnName == "-" {
+			continue
+		}
+
+		nestedPrefix, hasPrefix := field.Tag.Lookup("dbprefix")
+		if hasPrefix || (field.Anonymous && columnName == "" && isNestedStruct(field.Type)) {
+			nestedAssigned, err := assignNested(fieldValue, prefix+nestedPrefix, columnValues, mapper)
+			if err != nil {
+				return false, err
+			}
+
+			assigned = assigned || nestedAssigned
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if columnName == "" {
+			columnName = field.Name
+			if mapper != nil {
+				columnName = mapper(columnName)
+			}
+		}
+
+		value, ok := columnValues[strings.ToLower(prefix+columnName)]
+		if !ok {
+			continue
+		}
+
+		err = assignValue(fieldValue, value.value, value.databaseType)
+		if err != nil {
+			return false, fmt.Errorf("bccdata: column %s: %w", prefix+columnName, err)
+		}
+
+		assigned = assigned || value.value != nil
+	}
+
+	return assigned, nil
+}
+
+func assignNested(fieldValue reflect.Value, prefix string, columnValues map[string]columnValue, mapper NameMapper) (assigned bool, err error) {
+	var (
+		nestedValue reflect.Value
+	)
+
+	if fieldValue.Kind() != reflect.Ptr {
+		return assignStruct(fieldValue, prefix, columnValues, mapper)
+	}
+
+	if !fieldValue.CanSet() {
+		return false, nil
+	}
+
+	nestedValue = reflect.New(fieldValue.Type().Elem())
+
+	assigned, err = assignStruct(nestedValue.Elem(), prefix, columnValues, mapper)
+	if err != nil {
+		return false, err
+	}
+
+	if assigned {
+		fieldValue.Set(nestedValue)
+	} else {
+		fieldValue.Set(reflect.Zero(fieldValue.Type()))
+	}
+
+	return assigned, nil
+}
+
+func isNestedStruct(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	if fieldType.Kind() != reflect.Struct || fieldType == timeType {
+		return false
+	}
+
+	return !reflect.PtrTo(fieldType).Implements(scannerType)
+}
+
+func assignValue(fieldValue reflect.Value, value interface{}, databaseType string) (err error) {
+	if fieldValue.CanAddr() && fieldValue.Addr().Type().Implements(scannerType) {
+		return fieldValue.Addr().Interface().(sql.Scanner).Scan(value)
+	}
+
+	if value == nil {
+		fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		return nil
+	}
+
+	if fieldValue.Kind() == reflect.Ptr {
+		elementValue := reflect.New(fieldValue.Type().Elem())
+
+		err = assignValue(elementValue.Elem(), value, databaseType)
+		if err != nil {
+			return err
+		}
+
+		fieldValue.Set(elementValue)
+		return nil
+	}
+
+	value, err = coerceValue(value, databaseType, fieldValue.Type())
+	if err != nil {
+		return err
+	}
+
+	fieldValue.Set(reflect.ValueOf(value))
+
+	return nil
+}
+
+// Name Mapping
+
+// CreatedDate becomes created_date, and a run of capitals is kept together as
+// one word, so UserID becomes user_id and HTTPServer http_server.
+func SnakeCaseMapper(name string) string {
+	var (
+		runes   []rune
+		builder strings.Builder
+	)
+
+	runes = []rune(name)
+
+	for index, character := range runes {
+		if unicode.IsUpper(character) && index > 0 {
+			previous := runes[index-1]
+			nextIsLower := index+1 < len(runes) && unicode.IsLower(runes[index+1])
+
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				builder.WriteRune('_')
+			}
+		}
+
+		builder.WriteRune(unicode.ToLower(character))
+	}
+
+	return builder.String()
+}
+
+// Column names the package writes on its own account pass through the
+// context's NameMapper, when one is set.
+func (databaseContext *DatabaseContext) mappedName(name string) string {
+	if databaseContext.NameMapper == nil {
+		return name
+	}
+
+	return databaseContext.NameMapper(name)
+}
@@ -0,0 +1,271 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrSchemaMismatch = errors.New("bccdata: schema does not match entity description")
+	ErrNoColumnType   = errors.New("bccdata: column has no Type")
+)
+
+// Schema Creation
+
+// Creates the entity's table, if it is not there yet, from its declared
+// Columns. The primary key becomes an INTEGER PRIMARY KEY unless Columns
+// declares it with a type of its own; PrimaryKeys columns must all be
+// declared, and make up a table-level PRIMARY KEY. The created, updated,
+// version and soft-delete columns are added as INTEGERs when they are set and
+// not declared. Types are written as given, so they must suit the dialect.
+func (entityDescription *EntityDescription) CreateTable(transaction *sql.Tx) (err error) {
+	var (
+		databaseContext   *DatabaseContext
+		columnDefinitions []string
+		createStatement   string
+	)
+
+	databaseContext = entityDescription.Context
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	if len(entityDescription.PrimaryKeys) == 0 && !containsDeclaredColumn(entityDescription.Columns, entityDescription.PrimaryKey) {
+		columnDefinitions = append(columnDefinitions, databaseContext.quoteIdentifier(entityDescription.PrimaryKey)+" INTEGER PRIMARY KEY")
+	}
+
+	for _, column := range entityDescription.Columns {
+		if column.Type == "" {
+			return fmt.Errorf("%w: %s.%s", ErrNoColumnType, entityDescription.Name, column.Name)
+		}
+
+		columnDefinition := databaseContext.quoteIdentifier(column.Name) + " " + column.Type
+		if len(entityDescription.PrimaryKeys) == 0 && strings.EqualFold(column.Name, entityDescription.PrimaryKey) {
+			columnDefinition += " PRIMARY KEY"
+		} else if column.NotNull {
+			columnDefinition += " NOT NULL"
+		}
+
+		columnDefinitions = append(columnDefinitions, columnDefinition)
+	}
+
+	for _, columnName := range []string{entityDescription.CreatedDateColumn, entityDescription.UpdatedDateColumn, entityDescription.VersionColumn, entityDescription.SoftDeleteColumn} {
+		if columnName != "" && !containsDeclaredColumn(entityDescription.Columns, columnName) {
+			columnDefinitions = append(columnDefinitions, databaseContext.quoteIdentifier(columnName)+" INTEGER")
+		}
+	}
+
+	if len(entityDescription.PrimaryKeys) > 0 {
+		for _, keyColumn := range entityDescription.PrimaryKeys {
+			if !containsDeclaredColumn(entityDescription.Columns, keyColumn) {
+				return fmt.Errorf("%w: %s.%s", ErrNoColumnType, entityDescription.Name, keyColumn)
+			}
+		}
+
+		columnDefinitions = append(columnDefinitions, "PRIMARY KEY ("+strings.Join(databaseContext.quoteIdentifiers(entityDescription.PrimaryKeys), ", ")+")")
+	}
+
+	createStatement = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", databaseContext.quoteIdentifier(entityDescription.TableName), strings.Join(columnDefinitions, ", "))
+
+	_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, createStatement)
+
+	return err
+}
+
+func containsDeclaredColumn(columns []ColumnDef, columnName string) bool {
+	for _, column := range columns {
+		if strings.EqualFold(column.Name, columnName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Schema Migration
+
+func (databaseContext *DatabaseContext) EnsureColumn(entityName, column, typeSQL string) (err error) {
+	var (
+		entityDescription EntityDescription
+		ok                bool
+		columns           map[string]bool
+		alterStatement    string
+	)
+
+	entityDescription, ok = databaseContext.lookupEntityDescription(entityName)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEntity, entityName)
+	}
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return err
+	}
+
+	columns, err = databaseContext.sourceColumns(context.Background(), entityName, databaseContext.quoteIdentifier(entityDescription.TableName))
+	if err != nil {
+		return err
+	}
+
+	if columns[strings.ToLower(column)] {
+		return nil
+	}
+
+	alterStatement = fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", databaseContext.quoteIdentifier(entityDescription.TableName), databaseContext.quoteIdentifier(column), typeSQL)
+
+	_, err = databaseContext.exec(context.Background(), nil, entityName, alterStatement)
+
+	return err
+}
+
+// An empty SELECT reports the columns of a table, or of an entity's SourceSQL,
+// on every driver without needing a dialect-specific catalog query. Names are
+// lowercased for comparison.
+func (databaseContext *DatabaseContext) sourceColumns(ctx context.Context, entityName string, source string) (columns map[string]bool, err error) {
+	var (
+		rows        *sql.Rows
+		columnNames []string
+	)
+
+	rows, err = databaseContext.query(ctx, nil, entityName, OpWrite, fmt.Sprintf("SELECT * FROM %s WHERE 1=0", source))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columnNames, err = rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	columns = make(map[string]bool)
+	for _, columnName := range columnNames {
+		columns[strings.ToLower(columnName)] = true
+	}
+
+	return columns, nil
+}
+
+// Schema Validation
+
+// Checks every registered entity against the live schema: its table or
+// SourceSQL can be read, and each column it names is there, as are each join
+// table, its keys, the target's key and any counter column. Every problem is
+// reported, in entity name order; a table that cannot be read at all is one
+// problem rather than one per column. Mismatches wrap ErrSchemaMismatch.
+func (databaseContext *DatabaseContext) ValidateAll(ctx context.Context) (problems []error) {
+	for _, entityDescription := range databaseContext.registeredEntityDescriptions() {
+		problems = append(problems, entityDescription.validate(ctx)...)
+	}
+
+	return problems
+}
+
+func (entityDescription *EntityDescription) validate(ctx context.Context) (problems []error) {
+	var (
+		databaseContext   *DatabaseContext
+		columns           map[string]bool
+		expectedColumns   []string
+		relationshipNames []string
+		err               error
+	)
+
+	databaseContext = entityDescription.Context
+
+	_, err = entityDescription.readSQL("*", "")
+	if err != nil {
+		problems = append(problems, err)
+	}
+
+	columns, err = databaseContext.sourceColumns(ctx, entityDescription.Name, entityDescription.readSource())
+	if err != nil {
+		return append(problems, fmt.Errorf("bccdata: %s: reading %s: %w", entityDescription.Name, entityDescription.readSource(), err))
+	}
+
+	expectedColumns = append(expectedColumns, entityDescription.PrimaryKey)
+	expectedColumns = append(expectedColumns, entityDescription.managedColumns()...)
+	expectedColumns = append(expectedColumns, entityDescription.PrimaryKeys...)
+	expectedColumns = append(expectedColumns, entityDescription.SelectColumns...)
+	for _, column := range entityDescription.Columns {
+		expectedColumns = append(expectedColumns, column.Name)
+	}
+	expectedColumns = append(expectedColumns, entityDescription.ReadOnlyColumns...)
+	expectedColumns = append(expectedColumns, entityDescription.WriteOnlyColumns...)
+
+	problems = append(problems, missingColumns(entityDescription.Name, entityDescription.readSource(), columns, expectedColumns)...)
+
+	for relationshipName := range entityDescription.Relationships {
+		relationshipNames = append(relationshipNames, relationshipName)
+	}
+
+	sort.Strings(relationshipNames)
+
+	for _, relationshipName := range relationshipNames {
+		relationship := entityDescription.Relationships[relationshipName]
+
+		problems = append(problems, missingColumns(entityDescription.Name, entityDescription.readSource(), columns, []string{relationship.CounterColumn})...)
+		problems = append(problems, entityDescription.validateRelationship(ctx, relationshipName, relationship)...)
+	}
+
+	return problems
+}
+
+func (entityDescription *EntityDescription) validateRelationship(ctx context.Context, relationshipName string, relationship EntityRelationship) (problems []error) {
+	var (
+		databaseContext         *DatabaseContext
+		targetEntityDescription EntityDescription
+		ok                      bool
+		columns                 map[string]bool
+		err                     error
+	)
+
+	databaseContext = entityDescription.Context
+	problemName := entityDescription.Name + " relationship " + relationshipName
+
+	if relationship.JoinTableName != "" {
+		columns, err = databaseContext.sourceColumns(ctx, entityDescription.Name, databaseContext.quoteIdentifier(relationship.JoinTableName))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("bccdata: %s: reading %s: %w", problemName, relationship.JoinTableName, err))
+		} else {
+			problems = append(problems, missingColumns(problemName, relationship.JoinTableName, columns, []string{relationship.SourceKey, relationship.ForeignKey})...)
+		}
+	}
+
+	targetEntityDescription, ok = databaseContext.lookupEntityDescription(relationship.EntityName)
+	if !ok {
+		return append(problems, fmt.Errorf("%w: %s: target %s", ErrUnknownEntity, problemName, relationship.EntityName))
+	}
+
+	// A target that cannot be read is reported under its own entity. A HasMany
+	// relationship keeps its ForeignKey there rather than in a join table.
+	targetColumn := relationship.TargetKey
+	if relationship.kind() == HasMany {
+		targetColumn = relationship.ForeignKey
+	}
+
+	if targetColumn != "" {
+		columns, err = databaseContext.sourceColumns(ctx, targetEntityDescription.Name, targetEntityDescription.readSource())
+		if err == nil {
+			problems = append(problems, missingColumns(problemName, targetEntityDescription.readSource(), columns, []string{targetColumn})...)
+		}
+	}
+
+	return problems
+}
+
+// Empty names are options left unset and are skipped.
+func missingColumns(problemName string, source string, columns map[string]bool, expectedColumns []string) (problems []error) {
+	for _, expectedColumn := range expectedColumns {
+		if expectedColumn != "" && !columns[strings.ToLower(expectedColumn)] {
+			problems = append(problems, fmt.Errorf("%w: %s: %s has no column %s", ErrSchemaMismatch, problemName, source, expectedColumn))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,82 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrNoSearchColumn = errors.New("bccdata: entity has no SearchColumn")
+
+// Full-Text Search
+
+// Matches query against SearchColumn using the dialect's full-text predicate,
+// ANDed with any conditions BeforeFind adds. What the query text may contain
+// is up to the dialect; see its SearchCondition.
+func (entityDescription *EntityDescription) SearchEntities(transaction *sql.Tx, query string) (entities []Entity, err error) {
+	var (
+		databaseContext *DatabaseContext
+		whereClause     *WhereClause
+		searchSQL       string
+		whereSQL        string
+		whereArgs       []interface{}
+		selectColumns   string
+		selectStatement string
+		rows            *sql.Rows
+	)
+
+	if entityDescription.SearchColumn == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoSearchColumn, entityDescription.Name)
+	}
+
+	databaseContext = entityDescription.Context
+	whereClause = &WhereClause{}
+
+	searchSQL, err = databaseContext.dialect().SearchCondition(databaseContext.quoteIdentifier(entityDescription.SearchColumn), entityDescription.SearchConfiguration)
+	if err != nil {
+		return nil, err
+	}
+
+	err = entityDescription.runBeforeFind(whereClause)
+	if err != nil {
+		return nil, err
+	}
+
+	whereSQL, whereArgs, err = whereClause.build(databaseContext, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if whereSQL != "" {
+		searchSQL += " AND " + whereSQL
+	}
+
+	selectColumns, err = entityDescription.selectColumns("")
+	if err != nil {
+		return nil, err
+	}
+
+	selectStatement, err = entityDescription.readSQL(selectColumns, " WHERE "+searchSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = databaseContext.query(context.Background(), transaction, entityDescription.Name, OpRead, selectStatement, append([]interface{}{query}, whereArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entities, err = entityDescription.CreateFromRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = entityDescription.runAfterFind(entities)
+	if err != nil {
+		return nil, err
+	}
+
+	return entities, nil
+}
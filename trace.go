@@ -0,0 +1,78 @@
+package bccdata
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type traceContextKey struct{}
+
+type TraceEntry struct {
+	SQL      string
+	Args     []interface{}
+	Duration time.Duration
+	Err      error
+}
+
+type Trace struct {
+	mutex   sync.Mutex
+	entries []TraceEntry
+}
+
+// Query Tracing
+
+// Every statement run with the returned context, or a context derived from
+// it, is recorded in the Trace. Durations cover executing the statement, not
+// reading its rows.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	trace := &Trace{}
+	return context.WithValue(ctx, traceContextKey{}, trace), trace
+}
+
+func TraceFromContext(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceContextKey{}).(*Trace)
+	return trace
+}
+
+func (trace *Trace) Entries() []TraceEntry {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	return append([]TraceEntry(nil), trace.entries...)
+}
+
+func recordTrace(ctx context.Context, statementSQL string, args []interface{}, duration time.Duration, err error) {
+	trace := TraceFromContext(ctx)
+	if trace == nil {
+		return
+	}
+
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+
+	trace.entries = append(trace.entries, TraceEntry{
+		SQL:      statementSQL,
+		Args:     append([]interface{}(nil), args...),
+		Duration: duration,
+		Err:      err,
+	})
+}
+
+// Query Logging
+
+// LogQuery sees every statement the package runs, once it has run, with the
+// SQL and arguments as sent to the driver. It may be called from several
+// goroutines at once.
+type Logger interface {
+	LogQuery(sql string, args []interface{}, duration time.Duration, err error)
+}
+
+// Statements go to the context's Trace, if any, and then to the Logger.
+func (databaseContext *DatabaseContext) recordQuery(ctx context.Context, statementSQL string, args []interface{}, duration time.Duration, err error) {
+	recordTrace(ctx, statementSQL, args, duration, err)
+
+	if databaseContext.Logger != nil {
+		databaseContext.Logger.LogQuery(statementSQL, args, duration, err)
+	}
+}
@@ -0,0 +1,305 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type transactionContextKey struct{}
+
+var ErrInvalidSavepoint = errors.New("bccdata: savepoint name must be a plain identifier")
+
+const (
+	DefaultTransactionRetryLimit   = 3
+	DefaultTransactionRetryBackoff = 10 * time.Millisecond
+)
+
+// Transactions
+
+// fn runs in a fresh transaction that is committed when it returns nil and
+// rolled back otherwise. When the attempt fails with a serialization failure
+// or deadlock (SQLSTATE 40001 or 40P01), whether from fn or from the commit,
+// the whole closure is retried with exponential backoff, up to
+// TransactionRetryLimit extra attempts. A zero limit means
+// DefaultTransactionRetryLimit and a negative one disables retrying, so fn
+// must be safe to run more than once.
+func (databaseContext *DatabaseContext) RunInTransaction(options *sql.TxOptions, fn func(transaction *sql.Tx) error) (err error) {
+	var (
+		retryLimit int
+		backoff    time.Duration
+	)
+
+	retryLimit = databaseContext.TransactionRetryLimit
+	if retryLimit == 0 {
+		retryLimit = DefaultTransactionRetryLimit
+	}
+
+	backoff = databaseContext.TransactionRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultTransactionRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = databaseContext.runTransaction(options, fn)
+		if err == nil || attempt >= retryLimit || !IsSerializationFailure(err) {
+			return err
+		}
+
+		time.Sleep(backoff << attempt)
+	}
+}
+
+// fn runs once in a fresh transaction, committed when it returns nil and
+// rolled back otherwise, with no retrying. Every method handed fn's
+// transaction runs on that transaction's connection, so a find after a create
+// sees the new row before it is committed.
+func (databaseContext *DatabaseContext) InTransaction(fn func(transaction *sql.Tx) error) error {
+	return databaseContext.runTransaction(nil, fn)
+}
+
+// Like RunInTransaction, but fn is tried up to maxAttempts times in all, each
+// time in a fresh transaction, and any error IsRetryable accepts is retried,
+// not only serialization failures. A nil IsRetryable means IsTransientError.
+// Backoff doubles from TransactionRetryBackoff between attempts, and the error
+// from the last attempt is the one returned.
+func (databaseContext *DatabaseContext) WithRetry(maxAttempts int, fn func(transaction *sql.Tx) error) (err error) {
+	var (
+		isRetryable func(err error) bool
+		backoff     time.Duration
+	)
+
+	isRetryable = databaseContext.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsTransientError
+	}
+
+	backoff = databaseContext.TransactionRetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultTransactionRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = databaseContext.runTransaction(nil, fn)
+		if err == nil || attempt >= maxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		time.Sleep(backoff << (attempt - 1))
+	}
+}
+
+func (databaseContext *DatabaseContext) runTransaction(options *sql.TxOptions, fn func(transaction *sql.Tx) error) (err error) {
+	var (
+		transaction *sql.Tx
+	)
+
+	transaction, err = databaseContext.connection("", OpWrite).BeginTx(context.Background(), options)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			transaction.Rollback()
+			panic(recovered)
+		}
+	}()
+
+	err = fn(transaction)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+// Savepoints
+
+// fn runs under a savepoint in transaction, which is released when fn returns
+// nil and rolled back to otherwise, undoing only what fn did; the transaction
+// itself carries on either way. Savepoints nest by name, so inner calls should
+// use names of their own. The name is written into the SQL and must be a plain
+// identifier of letters, digits and underscores.
+func (databaseContext *DatabaseContext) WithSavepoint(transaction *sql.Tx, name string, fn func() error) (err error) {
+	var (
+		rollbackErr error
+		releaseErr  error
+	)
+
+	if transaction == nil {
+		return ErrTransactionRequired
+	}
+
+	if !isSavepointName(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidSavepoint, name)
+	}
+
+	_, err = databaseContext.exec(context.Background(), transaction, "", "SAVEPOINT "+name)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			databaseContext.exec(context.Background(), transaction, "", "ROLLBACK TO SAVEPOINT "+name)
+			panic(recovered)
+		}
+	}()
+
+	err = fn()
+	if err != nil {
+		_, rollbackErr = databaseContext.exec(context.Background(), transaction, "", "ROLLBACK TO SAVEPOINT "+name)
+		if rollbackErr != nil {
+			return rollbackErr
+		}
+	}
+
+	_, releaseErr = databaseContext.exec(context.Background(), transaction, "", "RELEASE SAVEPOINT "+name)
+	if err == nil {
+		err = releaseErr
+	}
+
+	return err
+}
+
+func isSavepointName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for index, character := range name {
+		switch {
+		case character == '_', character >= 'a' && character <= 'z', character >= 'A' && character <= 'Z':
+		case character >= '0' && character <= '9' && index > 0:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Serialization failures, plus MySQL deadlocks and lock wait timeouts (errors
+// 1213 and 1205) and SQLite's busy and locked errors. Neither driver exposes
+// these through an interface, so they are matched in the error text.
+func IsTransientError(err error) bool {
+	var (
+		message string
+	)
+
+	if err == nil {
+		return false
+	}
+
+	if IsSerializationFailure(err) {
+		return true
+	}
+
+	message = err.Error()
+
+	return strings.Contains(message, "Error 1213") ||
+		strings.Contains(message, "Error 1205") ||
+		strings.Contains(message, "database is locked") ||
+		strings.Contains(message, "database table is locked") ||
+		strings.Contains(message, "SQLITE_BUSY") ||
+		strings.Contains(message, "SQLITE_LOCKED")
+}
+
+// Drivers that expose the SQLSTATE (pgx, lib/pq) are checked by code; others
+// fall back to matching the code in its "SQLSTATE 40001" form, or Postgres'
+// message, in the error text. A bare 40001 could be any number in a message.
+func IsSerializationFailure(err error) bool {
+	var (
+		stateError interface{ SQLState() string }
+		message    string
+	)
+
+	if err == nil {
+		return false
+	}
+
+	if errors.As(err, &stateError) {
+		switch stateError.SQLState() {
+		case "40001", "40P01":
+			return true
+		default:
+			return false
+		}
+	}
+
+	message = err.Error()
+
+	return strings.Contains(message, "SQLSTATE 40001") ||
+		strings.Contains(message, "SQLSTATE 40P01") ||
+		strings.Contains(message, "could not serialize access") ||
+		strings.Contains(message, "deadlock detected")
+}
+
+// Statement Batches
+
+// With MultiStatementExec set, the statements go to the database joined into
+// one exec, which the driver must be configured to accept (MySQL needs
+// multiStatements=true in its DSN). Otherwise they run one by one inside a
+// transaction, so a failure applies none of them; a joined exec is only as
+// atomic as the database makes it. Statements take no arguments.
+func (databaseContext *DatabaseContext) ExecBatch(statements []string) (err error) {
+	var (
+		transaction *sql.Tx
+	)
+
+	if len(statements) == 0 {
+		return nil
+	}
+
+	if databaseContext.MultiStatementExec {
+		_, err = databaseContext.exec(context.Background(), nil, "", strings.Join(statements, ";\n"))
+		return err
+	}
+
+	transaction, err = databaseContext.begin(context.Background(), "", OpWrite)
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		_, err = databaseContext.exec(context.Background(), transaction, "", statement)
+		if err != nil {
+			goto cleanup
+		}
+	}
+
+cleanup:
+	if err != nil {
+		transaction.Rollback()
+	} else {
+		err = transaction.Commit()
+	}
+
+	return err
+}
+
+// Ambient Transactions
+
+// The *Context finders and creators run in the transaction carried by ctx
+// when they are handed a nil transaction. An explicit transaction always wins.
+func ContextWithTx(ctx context.Context, transaction *sql.Tx) context.Context {
+	return context.WithValue(ctx, transactionContextKey{}, transaction)
+}
+
+func TxFromContext(ctx context.Context) *sql.Tx {
+	transaction, _ := ctx.Value(transactionContextKey{}).(*sql.Tx)
+	return transaction
+}
+
+func contextTransaction(ctx context.Context, transaction *sql.Tx) *sql.Tx {
+	if transaction != nil {
+		return transaction
+	}
+
+	return TxFromContext(ctx)
+}
@@ -0,0 +1,84 @@
+package bccdata
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrEntityType = errors.New("bccdata: entity is not of the requested type")
+
+// Typed Lookups
+
+// These wrap the methods of the same name and assert each entity to T, so
+// callers get their own type back. An entity of another type is returned as
+// ErrEntityType rather than a panic; T is usually the pointer type that
+// CreateZeroInstance returns.
+
+func FindEntity[T Entity](entityDescription *EntityDescription, transaction *sql.Tx, keyName *string, value interface{}) (typedEntity T, err error) {
+	var (
+		entity Entity
+	)
+
+	entity, err = entityDescription.FindEntity(transaction, keyName, value)
+	if err != nil {
+		return typedEntity, err
+	}
+
+	return assertEntity[T](entity)
+}
+
+func FindEntities[T Entity](entityDescription *EntityDescription, transaction *sql.Tx, keyName *string, value interface{}) (typedEntities []T, err error) {
+	var (
+		entities []Entity
+	)
+
+	entities, err = entityDescription.FindEntities(transaction, keyName, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return assertEntities[T](entities)
+}
+
+func Create[T Entity](entityDescription *EntityDescription, transaction *sql.Tx, args ...interface{}) (typedEntity T, err error) {
+	var (
+		entity Entity
+	)
+
+	entity, err = entityDescription.Create(transaction, args...)
+	if err != nil {
+		return typedEntity, err
+	}
+
+	return assertEntity[T](entity)
+}
+
+// A nil entity comes back as T's zero value.
+func assertEntity[T Entity](entity Entity) (typedEntity T, err error) {
+	if entity == nil {
+		return typedEntity, nil
+	}
+
+	typedEntity, ok := entity.(T)
+	if !ok {
+		return typedEntity, fmt.Errorf("%w: got %T, want %T", ErrEntityType, entity, typedEntity)
+	}
+
+	return typedEntity, nil
+}
+
+func assertEntities[T Entity](entities []Entity) (typedEntities []T, err error) {
+	typedEntities = make([]T, 0, len(entities))
+
+	for _, entity := range entities {
+		typedEntity, err := assertEntity[T](entity)
+		if err != nil {
+			return nil, err
+		}
+
+		typedEntities = append(typedEntities, typedEntity)
+	}
+
+	return typedEntities, nil
+}
@@ -0,0 +1,374 @@
+package bccdata
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BatchResult is the error a batch write fails with when a single row is to
+// blame. FailedIndex is that row's index in the batch and SuccessCount how
+// many rows before it went in. Those rows stay applied in the caller's
+// transaction when one was passed, so the batch can resume after the failed
+// row; a transaction the batch began itself is rolled back whole.
+type BatchResult struct {
+	SuccessCount int
+	FailedIndex  int
+	Err          error
+}
+
+func (batchResult *BatchResult) Error() string {
+	return fmt.Sprintf("bccdata: batch row %d failed after %d rows: %v", batchResult.FailedIndex, batchResult.SuccessCount, batchResult.Err)
+}
+
+func (batchResult *BatchResult) Unwrap() error {
+	return batchResult.Err
+}
+
+const batchSavepoint = "bccdata_batch"
+
+var (
+	ErrNoConflictColumns = errors.New("bccdata: upsert needs conflict columns")
+	ErrRowWidth          = errors.New("bccdata: row does not match the insertable columns")
+	ErrConflictWhere     = errors.New("bccdata: Upsert with ConflictWhere needs a SoftDeleteColumn")
+)
+
+// Upserts
+
+// The single-row form, returning the row as it stands afterwards. args are
+// values for the insertable columns, in the order Columns declares them, and
+// conflictKey must be one of those columns so the row can be found again.
+// When no row holds the conflict value yet, the upsert is a create: the
+// create hooks run around it and CreatedDateColumn is stamped. An update runs
+// no hooks. ConflictWhere, a raw SQL predicate such as "deleted_at IS NULL",
+// targets a partial unique index on SQLite and PostgreSQL. Upsert then finds
+// and stamps the row among those not soft-deleted, which is what such an index
+// covers, so ConflictWhere without a SoftDeleteColumn is ErrConflictWhere.
+func (entityDescription *EntityDescription) Upsert(transaction *sql.Tx, conflictKey string, args ...interface{}) (entity Entity, err error) {
+	var (
+		databaseContext      *DatabaseContext
+		commitAtEnd          bool
+		columnNames          []string
+		updateColumns        []string
+		conflictValue        interface{}
+		conflictFound        bool
+		conflictClause       *WhereClause
+		conflictSQL          string
+		conflictArgs         []interface{}
+		creating             bool
+		updateCreatedDateSQL string
+	)
+
+	databaseContext = entityDescription.Context
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	columnNames = entityDescription.insertColumns()
+	if len(columnNames) == 0 {
+		return nil, ErrNoInsertColumns
+	}
+
+	if len(args) != len(columnNames) {
+		return nil, fmt.Errorf("%w: %d values for %d columns", ErrRowWidth, len(args), len(columnNames))
+	}
+
+	for index, columnName := range columnNames {
+		if strings.EqualFold(columnName, conflictKey) {
+			conflictValue = args[index]
+			conflictFound = true
+		} else {
+			updateColumns = append(updateColumns, columnName)
+		}
+	}
+
+	if !conflictFound {
+		return nil, fmt.Errorf("%w: %s has no insertable column %s", ErrUnknownColumn, entityDescription.Name, conflictKey)
+	}
+
+	if entityDescription.ConflictWhere != "" && entityDescription.SoftDeleteColumn == "" {
+		return nil, fmt.Errorf("%w: %s", ErrConflictWhere, entityDescription.Name)
+	}
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpWrite)
+		if err != nil {
+			return nil, err
+		}
+
+		commitAtEnd = true
+	}
+
+	conflictClause = Where(conflictKey, "=", conflictValue)
+	if entityDescription.ConflictWhere != "" {
+		conflictClause.And(entityDescription.SoftDeleteColumn, "IS NULL", nil)
+	}
+
+	_, err = entityDescription.reselectWhere(context.Background(), transaction, conflictClause)
+	creating = errors.Is(err, ErrNotFound)
+	if err != nil && !creating {
+		goto cleanup
+	}
+
+	args = entityDescription.bindValues(columnNames, args)
+
+	if creating {
+		err = entityDescription.runBeforeCreate(args)
+		if err != nil {
+			goto cleanup
+		}
+	}
+
+	_, err = entityDescription.upsertChunk(transaction, columnNames, []string{conflictKey}, updateColumns, [][]interface{}{args})
+	if err != nil {
+		goto cleanup
+	}
+
+	if creating && entityDescription.CreatedDateColumn != "" {
+		conflictSQL, conflictArgs, err = conflictClause.build(databaseContext, "")
+		if err != nil {
+			goto cleanup
+		}
+
+		if entityDescription.ConflictWhere != "" {
+			conflictSQL += " AND (" + entityDescription.ConflictWhere + ")"
+		}
+
+		updateCreatedDateSQL = fmt.Sprintf("UPDATE %s SET %s=? WHERE %s", databaseContext.quoteIdentifier(entityDescription.TableName), databaseContext.quoteIdentifier(entityDescription.CreatedDateColumn), conflictSQL)
+
+		_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, updateCreatedDateSQL, append([]interface{}{time.Now().Unix()}, conflictArgs...)...)
+		if err != nil {
+			goto cleanup
+		}
+	}
+
+	entity, err = entityDescription.reselectWhere(context.Background(), transaction, conflictClause)
+	if err != nil {
+		goto cleanup
+	}
+
+	if creating {
+		err = entityDescription.runAfterCreate(entity)
+	}
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entity, nil
+}
+
+// Each row holds values for the insertable columns, in the order Columns
+// declares them. A row that collides on conflictColumns, which must all be
+// insertable columns, updates every other insertable column instead. Rows are
+// sent as multi-row statements sized to the dialect's parameter limit, all
+// inside one transaction. Each statement runs under a savepoint; when one
+// fails, its rows are retried one at a time to find the culprit, which is
+// reported as a *BatchResult. The count is the driver's; MySQL counts an
+// updated row twice. Unlike Upsert, no hooks run and CreatedDateColumn is left
+// alone, since nothing tells inserted rows apart.
+func (entityDescription *EntityDescription) UpsertMany(transaction *sql.Tx, conflictColumns []string, rows [][]interface{}) (affectedCount int64, err error) {
+	var (
+		databaseContext *DatabaseContext
+		commitAtEnd     bool
+		columnNames     []string
+		updateColumns   []string
+		rowsPerChunk    int
+		chunkAffected   int64
+		failedOffset    int
+	)
+
+	databaseContext = entityDescription.Context
+
+	err = entityDescription.checkWritable()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	if len(conflictColumns) == 0 {
+		return 0, ErrNoConflictColumns
+	}
+
+	columnNames = entityDescription.insertColumns()
+	if len(columnNames) == 0 {
+		return 0, ErrNoInsertColumns
+	}
+
+	for index, row := range rows {
+		if len(row) != len(columnNames) {
+			return 0, fmt.Errorf("%w: row %d has %d values for %d columns", ErrRowWidth, index, len(row), len(columnNames))
+		}
+	}
+
+	for _, conflictColumn := range conflictColumns {
+		if !containsColumn(columnNames, conflictColumn) {
+			return 0, fmt.Errorf("%w: %s has no insertable column %s", ErrUnknownColumn, entityDescription.Name, conflictColumn)
+		}
+	}
+
+	for _, columnName := range columnNames {
+		if !containsColumn(conflictColumns, columnName) {
+			updateColumns = append(updateColumns, columnName)
+		}
+	}
+
+	rowsPerChunk = databaseContext.parameterChunkSize() / len(columnNames)
+	if rowsPerChunk < 1 {
+		rowsPerChunk = 1
+	}
+
+	if transaction == nil {
+		transaction, err = databaseContext.begin(context.Background(), entityDescription.Name, OpWrite)
+		if err != nil {
+			return 0, err
+		}
+
+		commitAtEnd = true
+	}
+
+	for start := 0; start < len(rows); start += rowsPerChunk {
+		end := start + rowsPerChunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		chunkAffected, failedOffset, err = entityDescription.upsertIsolated(transaction, columnNames, conflictColumns, updateColumns, rows[start:end])
+		if err != nil {
+			if failedOffset >= 0 {
+				err = &BatchResult{SuccessCount: start + failedOffset, FailedIndex: start + failedOffset, Err: err}
+			}
+
+			goto cleanup
+		}
+
+		affectedCount += chunkAffected
+	}
+
+cleanup:
+	if commitAtEnd {
+		if err != nil {
+			transaction.Rollback()
+		} else {
+			err = transaction.Commit()
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return affectedCount, nil
+}
+
+// failedOffset is the offending row's position in rows, or -1 when the error
+// is not down to one row. A chunk that only fails as a whole, as PostgreSQL
+// does when two of its rows hit the same conflict, is applied row by row.
+func (entityDescription *EntityDescription) upsertIsolated(transaction *sql.Tx, columnNames []string, conflictColumns []string, updateColumns []string, rows [][]interface{}) (affectedCount int64, failedOffset int, err error) {
+	var (
+		rowAffected  int64
+		statementErr error
+	)
+
+	affectedCount, statementErr, err = entityDescription.upsertSavepoint(transaction, columnNames, conflictColumns, updateColumns, rows)
+	if err != nil {
+		return 0, -1, err
+	}
+
+	if statementErr == nil {
+		return affectedCount, -1, nil
+	}
+
+	if len(rows) == 1 {
+		return 0, 0, statementErr
+	}
+
+	affectedCount = 0
+
+	for offset := range rows {
+		rowAffected, statementErr, err = entityDescription.upsertSavepoint(transaction, columnNames, conflictColumns, updateColumns, rows[offset:offset+1])
+		if err != nil {
+			return 0, -1, err
+		}
+
+		if statementErr != nil {
+			return 0, offset, statementErr
+		}
+
+		affectedCount += rowAffected
+	}
+
+	return affectedCount, -1, nil
+}
+
+// A failed upsert is rolled back to the savepoint and returned as
+// statementErr, leaving the transaction usable; err is a failure of the
+// savepoint itself.
+func (entityDescription *EntityDescription) upsertSavepoint(transaction *sql.Tx, columnNames []string, conflictColumns []string, updateColumns []string, rows [][]interface{}) (affectedCount int64, statementErr error, err error) {
+	var (
+		databaseContext *DatabaseContext
+		result          sql.Result
+	)
+
+	databaseContext = entityDescription.Context
+
+	_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, "SAVEPOINT "+batchSavepoint)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	result, statementErr = entityDescription.upsertChunk(transaction, columnNames, conflictColumns, updateColumns, rows)
+	if statementErr == nil {
+		affectedCount, statementErr = result.RowsAffected()
+	}
+
+	if statementErr != nil {
+		_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, "ROLLBACK TO SAVEPOINT "+batchSavepoint)
+		if err != nil {
+			return 0, statementErr, err
+		}
+	}
+
+	_, err = databaseContext.exec(context.Background(), transaction, entityDescription.Name, "RELEASE SAVEPOINT "+batchSavepoint)
+
+	return affectedCount, statementErr, err
+}
+
+func (entityDescription *EntityDescription) upsertChunk(transaction *sql.Tx, columnNames []string, conflictColumns []string, updateColumns []string, rows [][]interface{}) (result sql.Result, err error) {
+	var (
+		databaseContext *DatabaseContext
+		rowSQL          string
+		valuesSQL       []string
+		args            []interface{}
+		upsertStatement string
+	)
+
+	databaseContext = entityDescription.Context
+
+	rowSQL = "(" + placeholderList(len(columnNames)) + ")"
+	for _, row := range rows {
+		valuesSQL = append(valuesSQL, rowSQL)
+		args = append(args, entityDescription.bindValues(columnNames, row)...)
+	}
+
+	upsertStatement = fmt.Sprintf("INSERT INTO %s (%s) VALUES %s %s", databaseContext.quoteIdentifier(entityDescription.TableName), strings.Join(databaseContext.quoteIdentifiers(columnNames), ", "), strings.Join(valuesSQL, ", "), databaseContext.dialect().UpsertClause(databaseContext.quoteIdentifiers(conflictColumns), entityDescription.ConflictWhere, databaseContext.quoteIdentifiers(updateColumns)))
+
+	return databaseContext.exec(context.Background(), transaction, entityDescription.Name, upsertStatement, args...)
+}
@@ -0,0 +1,159 @@
+package bccdata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnsupportedOperator = errors.New("bccdata: unsupported where operator")
+
+type WhereCondition struct {
+	Column   string
+	Operator string
+	Value    interface{}
+}
+
+type WhereClause struct {
+	Conditions []WhereCondition
+}
+
+var supportedOperators = map[string]bool{
+	"=":           true,
+	"!=":          true,
+	"<":           true,
+	"<=":          true,
+	">":           true,
+	">=":          true,
+	"LIKE":        true,
+	"IN":          true,
+	"IS NULL":     true,
+	"IS NOT NULL": true,
+}
+
+func Where(column string, operator string, value interface{}) (whereClause *WhereClause) {
+	whereClause = &WhereClause{}
+	return whereClause.And(column, operator, value)
+}
+
+func (whereClause *WhereClause) And(column string, operator string, value interface{}) *WhereClause {
+	whereClause.Conditions = append(whereClause.Conditions, WhereCondition{Column: column, Operator: operator, Value: value})
+	return whereClause
+}
+
+// Conditions are ANDed together in the order they were added. Unqualified
+// columns are prefixed with qualifier when one is given, which keeps hook-added
+// conditions pointed at the entity's own table inside a join.
+func (whereClause *WhereClause) build(databaseContext *DatabaseContext, qualifier string) (whereSQL string, args []interface{}, err error) {
+	var (
+		predicates []string
+		column     string
+		operator   string
+	)
+
+	if whereClause == nil {
+		return "", nil, nil
+	}
+
+	for _, condition := range whereClause.Conditions {
+		operator = strings.ToUpper(strings.TrimSpace(condition.Operator))
+		if !supportedOperators[operator] {
+			return "", nil, fmt.Errorf("%w: %s", ErrUnsupportedOperator, condition.Operator)
+		}
+
+		column = condition.Column
+		if qualifier != "" && !strings.Contains(column, ".") {
+			column = qualifier + "." + column
+		}
+
+		// The null tests take no value.
+		if operator == "IS NULL" || operator == "IS NOT NULL" {
+			predicates = append(predicates, databaseContext.quoteIdentifier(column)+" "+operator)
+			continue
+		}
+
+		if operator == "IN" {
+			values, ok := condition.Value.([]interface{})
+			if !ok {
+				return "", nil, fmt.Errorf("bccdata: IN on %s needs a []interface{} value", condition.Column)
+			}
+
+			// IN () is a syntax error, and an empty set matches nothing.
+			if len(values) == 0 {
+				predicates = append(predicates, "1=0")
+				continue
+			}
+
+			predicates = append(predicates, fmt.Sprintf("%s IN (%s)", databaseContext.quoteIdentifier(column), placeholderList(len(values))))
+			args = append(args, values...)
+			continue
+		}
+
+		predicates = append(predicates, fmt.Sprintf("%s%s?", databaseContext.quoteIdentifier(column), operatorSQL(operator)))
+		args = append(args, condition.Value)
+	}
+
+	return strings.Join(predicates, " AND "), args, nil
+}
+
+// Like build, but prefixed with WHERE, and empty when there are no conditions.
+func (whereClause *WhereClause) whereSQL(databaseContext *DatabaseContext, qualifier string) (whereSQL string, args []interface{}, err error) {
+	whereSQL, args, err = whereClause.build(databaseContext, qualifier)
+	if err != nil || whereSQL == "" {
+		return "", args, err
+	}
+
+	return " WHERE " + whereSQL, args, nil
+}
+
+// Finders that add their own conditions work on a copy, leaving the caller's
+// clause untouched. A nil clause clones to an empty one.
+func (whereClause *WhereClause) clone() *WhereClause {
+	if whereClause == nil {
+		return &WhereClause{}
+	}
+
+	return &WhereClause{Conditions: append([]WhereCondition(nil), whereClause.Conditions...)}
+}
+
+func placeholderList(count int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", count), ", ")
+}
+
+func operatorSQL(operator string) string {
+	if operator == "LIKE" {
+		return " LIKE "
+	}
+
+	return operator
+}
+
+// Find Hooks
+
+// Soft-deleted rows are filtered out here, ahead of BeforeFind, so every
+// finder leaves them out.
+func (entityDescription *EntityDescription) runBeforeFind(whereClause *WhereClause) error {
+	if entityDescription.SoftDeleteColumn != "" {
+		whereClause.And(entityDescription.SoftDeleteColumn, "IS NULL", nil)
+	}
+
+	if entityDescription.BeforeFind == nil {
+		return nil
+	}
+
+	return entityDescription.BeforeFind(whereClause)
+}
+
+// Lazy loaders go on first, so AfterFind sees entities ready to use.
+func (entityDescription *EntityDescription) runAfterFind(entities []Entity) error {
+	err := entityDescription.attachLazyLoaders(entities)
+	if err != nil {
+		return err
+	}
+
+	if entityDescription.AfterFind == nil {
+		return nil
+	}
+
+	return entityDescription.AfterFind(entities)
+}